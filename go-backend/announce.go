@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// handleAnnounce broadcasts a server announcement to one room, or to every
+// room when no roomId is given. It requires the admin API key.
+func handleAnnounce(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			writeJSONError(w, http.StatusForbidden, "Admin API key required")
+			return
+		}
+
+		var req struct {
+			Message string `json:"message"`
+			RoomID  string `json:"roomId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		req.Message = strings.TrimSpace(req.Message)
+		if req.Message == "" {
+			writeJSONError(w, http.StatusBadRequest, "message is required")
+			return
+		}
+
+		var rooms []*Room
+		if req.RoomID != "" {
+			room, exists := hub.GetRoom(req.RoomID)
+			if !exists {
+				writeJSONError(w, http.StatusNotFound, "Room not found")
+				return
+			}
+			rooms = []*Room{room}
+		} else {
+			rooms = hub.ListRooms()
+		}
+
+		delivered := 0
+		for _, room := range rooms {
+			if room.announce(req.Message) {
+				delivered++
+			}
+		}
+		slog.Info("announcement sent", "event", "announcement", "roomId", req.RoomID, "rooms", delivered)
+		writeJSON(w, http.StatusOK, map[string]int{"rooms": delivered})
+	}
+}
+
+// announce broadcasts a server announcement to everyone in the room. It
+// reports false if the room was torn down after it was looked up.
+func (r *Room) announce(text string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return false
+	}
+	r.broadcastLocked(Message{Type: "announcement", RoomID: r.ID, Text: text})
+	return true
+}
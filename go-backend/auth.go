@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// authDisabled skips token checks and trusts the username query
+	// parameter. Only meant for local development. It is the default for
+	// every Hub, see withAuthDisabled.
+	authDisabled = envBool("AUTH_DISABLED", false)
+	jwtSecret    = []byte(envString("JWT_SECRET", ""))
+
+	// adminAPIKey authorizes operational endpoints via the X-Admin-Key
+	// header. Admin endpoints are unusable while it is unset.
+	adminAPIKey = envString("ADMIN_API_KEY", "")
+)
+
+var errMissingToken = errors.New("missing token")
+
+// authClaims are the JWT claims issued by the main app. Subject carries
+// the user ID.
+type authClaims struct {
+	Username string `json:"username"`
+	jwt.RegisteredClaims
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// secretEqual compares secrets in constant time; an empty expected value
+// never matches
+func secretEqual(given, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// isAdmin reports whether r carries the admin API key
+func isAdmin(r *http.Request) bool {
+	return secretEqual(r.Header.Get("X-Admin-Key"), adminAPIKey)
+}
+
+// authenticate validates the HS256 token from the "token" query parameter
+// or a bearer Authorization header
+func authenticate(r *http.Request) (*authClaims, error) {
+	tokenString := r.URL.Query().Get("token")
+	if tokenString == "" {
+		tokenString = bearerToken(r)
+	}
+	if tokenString == "" {
+		return nil, errMissingToken
+	}
+
+	claims := &authClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(*jwt.Token) (any, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	if claims.Username == "" {
+		claims.Username = claims.Subject
+	}
+	return claims, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+)
+
+// breakoutScopedMessageTypes are broadcasts that only reach the sender's
+// breakout (or the main room, for clients not in one). Presence updates
+// such as join, leave and media-state stay room-wide so every roster keeps
+// working.
+var breakoutScopedMessageTypes = map[string]bool{
+	"chat":       true,
+	"file-share": true,
+	"typing":     true,
+}
+
+// scopeLocked stamps a breakout-scoped broadcast with its sender's current
+// breakout so delivery here, on other instances and in chat history
+// replay all respect it. The caller must hold r.mu.
+func (r *Room) scopeLocked(msg Message) Message {
+	if !breakoutScopedMessageTypes[msg.Type] {
+		return msg
+	}
+	msg.Breakout = ""
+	if sender, ok := r.Clients[msg.From]; ok {
+		msg.Breakout = sender.Breakout
+	}
+	return msg
+}
+
+// reachesLocked reports whether a delivered msg should reach client given
+// their breakouts. Service clients hear every breakout. The caller must
+// hold r.mu.
+func (r *Room) reachesLocked(msg Message, client *Client) bool {
+	return !breakoutScopedMessageTypes[msg.Type] || msg.Breakout == client.Breakout || client.Service
+}
+
+// sameBreakoutLocked reports whether two local clients can signal each
+// other directly. A sender that is not a local member doesn't restrict
+// anything. The caller must hold r.mu.
+func (r *Room) sameBreakoutLocked(fromID string, to *Client) bool {
+	from, ok := r.Clients[fromID]
+	return !ok || from.Breakout == to.Breakout
+}
+
+// createBreakout moves the listed clients into the named breakout. Only the
+// host may create breakouts; clients already in another breakout are
+// moved. Everyone is told so rosters can group participants.
+func (r *Room) createBreakout(from *Client, name string, clientIDs []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Host != from.ID {
+		from.sendError("not-host", "only the host can create breakout rooms")
+		return
+	}
+	for _, id := range clientIDs {
+		if _, ok := r.Clients[id]; !ok {
+			from.sendError("peer-not-found", "client "+id+" is not in this room")
+			return
+		}
+	}
+
+	for _, id := range clientIDs {
+		r.Clients[id].Breakout = name
+	}
+	slog.Info("breakout created", "event", "breakout-created", "roomId", r.ID, "breakout", name, "clients", len(clientIDs))
+	msg := Message{Type: "breakout-created", From: from.ID, RoomID: r.ID, Breakout: name, ClientIDs: slices.Clone(clientIDs)}
+	r.broadcastLocked(msg)
+	from.Send(msg)
+}
+
+// closeBreakout returns everyone in the named breakout, or in every
+// breakout when name is empty, to the main room. Only the host may close
+// breakouts.
+func (r *Room) closeBreakout(from *Client, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Host != from.ID {
+		from.sendError("not-host", "only the host can close breakout rooms")
+		return
+	}
+
+	var returned []string
+	for id, c := range r.Clients {
+		if c.Breakout != "" && (name == "" || c.Breakout == name) {
+			c.Breakout = ""
+			returned = append(returned, id)
+		}
+	}
+	if len(returned) == 0 {
+		return
+	}
+	slog.Info("breakout closed", "event", "breakout-closed", "roomId", r.ID, "breakout", name, "clients", len(returned))
+	msg := Message{Type: "breakout-closed", From: from.ID, RoomID: r.ID, Breakout: name, ClientIDs: returned}
+	r.broadcastLocked(msg)
+	from.Send(msg)
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client represents a connected websocket client
+type Client struct {
+	Conn   *websocket.Conn
+	ID     string
+	RoomID string
+	// Username may change via rename; it is guarded by the room's mu once
+	// the client is admitted
+	Username string
+	// UserID is the authenticated user behind this connection, empty when
+	// auth is disabled
+	UserID string
+	// Service marks an SFU or bot peer, see service.go
+	Service bool
+	// SessionID is unique to this connection
+	SessionID string
+	// Protocol is the signaling protocol version negotiated at upgrade, so
+	// handlers can branch on it as the message format evolves
+	Protocol string
+	// codec is the wire format that goes with Protocol
+	codec messageCodec
+	// Capabilities is what the client advertised at join time (codecs,
+	// max resolution, data channels...), relayed to peers uninterpreted
+	Capabilities json.RawMessage
+	JoinedAt     time.Time
+	// countedIP is the address charged against maxConnectionsPerIP for
+	// this connection, invalid if it was exempt
+	countedIP netip.Addr
+	// LastSeen is when the client last sent a message, as Unix nanoseconds
+	LastSeen atomic.Int64
+
+	// admitted is set once the client is a full room member rather than
+	// waiting for the host's approval
+	admitted atomic.Bool
+	// evicting is set once Send has found the buffer full and started
+	// closing the client
+	evicting atomic.Bool
+
+	// Latest mic/camera state, guarded by the room's mu
+	AudioEnabled bool
+	VideoEnabled bool
+
+	// Latest connection stats and derived quality level, also guarded by
+	// the room's mu. statsAt is when the last report was accepted.
+	Stats   ConnectionStats
+	Quality string
+	statsAt time.Time
+
+	// Breakout is the breakout room the client is in, "" for the main
+	// room. Guarded by the room's mu.
+	Breakout string
+
+	// Typing indicator state, see setTyping
+	typingMu    sync.Mutex
+	typing      bool
+	typingTimer *time.Timer
+
+	// send buffers outbound messages for writePump so a slow client never
+	// blocks the goroutine that is broadcasting to it. It is the only path
+	// to the connection, so messages reach the client in exactly the order
+	// they were queued. See Send for how backpressure is handled.
+	send chan Message
+	// ctx is canceled when the client is shut down, whether by Close or
+	// because its hub is shutting down. Every goroutine serving the client
+	// exits on it; done is ctx.Done().
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      <-chan struct{}
+	closeOnce sync.Once
+
+	// writeMu serializes writes to Conn, which gorilla/websocket requires
+	writeMu sync.Mutex
+	// writeWait bounds each write, normally the writeWait constant
+	writeWait time.Duration
+}
+
+var (
+	errClientClosed   = errors.New("client closed")
+	errSendBufferFull = errors.New("send buffer full")
+)
+
+// Application close codes (4000-4999 is reserved for these by RFC 6455)
+// that let the frontend tell evictions apart from network failures and
+// decide whether reconnecting makes sense
+const (
+	closeRoomFull            = 4001
+	closeKicked              = 4002
+	closeRateLimited         = 4003
+	closeIdleTimeout         = 4004
+	closeRoomClosed          = 4005
+	closeAdmissionDenied     = 4006
+	closeDuplicateID         = 4007
+	closeDuplicateSession    = 4008
+	closeSlowConsumer        = 4009
+	closeRoomNotFound        = 4010
+	closeDisconnectedByAdmin = 4011
+	closeRoomLocked          = 4012
+)
+
+// closeCodes maps the type of a SendAndClose message to the close code sent
+// after it. Types not listed close with CloseNormalClosure.
+var closeCodes = map[string]int{
+	"room-full":             closeRoomFull,
+	"kicked":                closeKicked,
+	"rate-limited":          closeRateLimited,
+	"idle-timeout":          closeIdleTimeout,
+	"room-closed":           closeRoomClosed,
+	"admission-denied":      closeAdmissionDenied,
+	"duplicate-id":          closeDuplicateID,
+	"duplicate-session":     closeDuplicateSession,
+	"room-not-found":        closeRoomNotFound,
+	"disconnected-by-admin": closeDisconnectedByAdmin,
+	"room-locked":           closeRoomLocked,
+	"server-at-capacity":    websocket.CloseTryAgainLater,
+	// 1007: the client kept sending data that wasn't valid for the protocol
+	"too-many-invalid-messages": websocket.CloseInvalidFramePayloadData,
+}
+
+// droppableMessageTypes are high-volume messages a client can do without
+// under backpressure: ICE gathers plenty of spare candidates and the rest
+// are superseded by the next update. Everything else, notably offers,
+// answers and chat, must be delivered.
+var droppableMessageTypes = map[string]bool{
+	"ice-candidate":      true,
+	"typing":             true,
+	"stats-report":       true,
+	"connection-quality": true,
+}
+
+// newClient wraps conn. The client is shut down when parent is canceled,
+// as well as when it is closed directly.
+func newClient(parent context.Context, conn *websocket.Conn, id, roomID, username string) *Client {
+	ctx, cancel := context.WithCancel(parent)
+	c := &Client{
+		Conn:      conn,
+		ID:        id,
+		RoomID:    roomID,
+		Username:  username,
+		SessionID: randomString(16),
+		codec:     jsonCodec{},
+		JoinedAt:  time.Now(),
+		// Assume media is on until the client says otherwise
+		AudioEnabled: true,
+		VideoEnabled: true,
+		send:         make(chan Message, sendBufferSize),
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         ctx.Done(),
+		writeWait:    writeWait,
+	}
+	// Closing the connection is what unblocks handleMessages' read
+	context.AfterFunc(ctx, c.Close)
+	return c
+}
+
+// touch records that the client just sent something
+func (c *Client) touch() {
+	c.LastSeen.Store(time.Now().UnixNano())
+}
+
+// lastSeenAt is when the client last sent a message
+func (c *Client) lastSeenAt() time.Time {
+	return time.Unix(0, c.LastSeen.Load())
+}
+
+// participant describes the client for roster snapshots. The caller must
+// hold the room's mu.
+func (c *Client) participant() Participant {
+	return Participant{
+		ClientID:     c.ID,
+		Username:     c.Username,
+		AudioEnabled: c.AudioEnabled,
+		VideoEnabled: c.VideoEnabled,
+		Quality:      c.Quality,
+		Breakout:     c.Breakout,
+		Capabilities: c.Capabilities,
+	}
+}
+
+// Send queues msg for delivery without blocking. Queued messages are
+// written in order, so a client always sees e.g. a join before that peer's
+// offer. Once the buffer is three quarters full, droppable messages are
+// silently discarded to keep the rest free for must-deliver ones; a client
+// whose buffer is completely full is too slow to keep up and gets
+// disconnected rather than stalling everyone else in the room.
+//
+// Must-deliver messages are never blocked on, the buffer never grows and
+// the writer never reorders them ahead of droppable ones. Send runs under
+// the room's mu, so blocking would stall the whole room; a growing buffer
+// would let one slow peer hold unbounded memory; and a priority writer
+// would break the ordering above. Reserving the last quarter of the buffer
+// is what keeps offers and answers from being crowded out instead. For
+// the same reason the close frame is written from another goroutine, since
+// writing it here could block for up to writeWait.
+func (c *Client) Send(msg Message) error {
+	select {
+	case <-c.done:
+		return errClientClosed
+	default:
+	}
+
+	if droppableMessageTypes[msg.Type] && !msg.closeAfter && len(c.send) >= cap(c.send)*3/4 {
+		slog.Debug("send buffer filling up, dropping message", "roomId", c.RoomID, "clientId", c.ID, "msgType", msg.Type, "correlationId", msg.CorrelationID)
+		messagesDroppedTotal.WithLabelValues(msg.Type).Inc()
+		return nil
+	}
+
+	select {
+	case c.send <- msg:
+		return nil
+	default:
+		if c.evicting.CompareAndSwap(false, true) {
+			slog.Warn("send buffer full, dropping client", "event", "slow-consumer", "roomId", c.RoomID, "clientId", c.ID)
+			go c.closeWith(closeSlowConsumer, "slow-consumer")
+		}
+		return errSendBufferFull
+	}
+}
+
+// sendError tells the client one of its messages was rejected
+func (c *Client) sendError(code, text string) {
+	c.Send(Message{Type: "error", RoomID: c.RoomID, Code: code, Text: text})
+}
+
+// ackChat answers a chat message carrying a msgId: chat-ack once it has
+// been queued for every recipient, or, when code is set, an error with the
+// same msgId so the sender can mark that message failed and retry it.
+// Without a msgId only the error is sent, as before acknowledgements.
+func (c *Client) ackChat(msgID, code, text string) {
+	switch {
+	case msgID == "":
+		if code != "" {
+			c.sendError(code, text)
+		}
+	case code != "":
+		c.Send(Message{Type: "error", RoomID: c.RoomID, Code: code, Text: text, MsgID: msgID})
+	default:
+		c.Send(stamped(Message{Type: "chat-ack", RoomID: c.RoomID, MsgID: msgID}))
+	}
+}
+
+// SendAndClose queues a final message and closes the connection once it
+// has been written, so the client learns why it is being disconnected
+func (c *Client) SendAndClose(msg Message) {
+	msg.closeAfter = true
+	if err := c.Send(msg); err != nil {
+		c.Close()
+	}
+}
+
+// Close shuts the client down. It is safe to call more than once and from
+// any goroutine; closing the connection also unblocks handleMessages.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.Conn.Close()
+	})
+}
+
+// writePump drains the send buffer onto the connection, in order, until
+// the client is closed. It is the only goroutine that writes data frames.
+func (c *Client) writePump() {
+	for {
+		var msg Message
+		select {
+		case <-c.done:
+			return
+		case msg = <-c.send:
+		}
+		if !c.writeMessage(msg) {
+			return
+		}
+	}
+}
+
+// writeMessage writes one queued message, closing the client afterwards if
+// the message asks for it or the write fails. It reports whether the
+// client is still open.
+func (c *Client) writeMessage(msg Message) bool {
+	msgBytes, err := c.codec.marshal(msg)
+	if err != nil {
+		slog.Error("error marshaling message", "roomId", c.RoomID, "clientId", c.ID, "msgType", msg.Type, "correlationId", msg.CorrelationID, "err", err)
+		return true
+	}
+	if err := c.write(c.codec.frameType(), msgBytes); err != nil {
+		// Write errors aren't retried. gorilla marks the connection failed
+		// after any error from the socket, since part of the frame may
+		// already be on the wire; every later write, close frames
+		// included, returns the same error. What recovers a momentary
+		// network hiccup is the client reconnecting with its clientId,
+		// which resumes its place in the room.
+		if connectionGone(err) {
+			slog.Debug("client connection gone, dropping message", "roomId", c.RoomID, "clientId", c.ID, "msgType", msg.Type, "correlationId", msg.CorrelationID, "err", err)
+		} else {
+			slog.Warn("error writing message", "event", "write-failed", "roomId", c.RoomID, "clientId", c.ID, "msgType", msg.Type, "correlationId", msg.CorrelationID, "err", err)
+		}
+		c.Close()
+		return false
+	}
+	if msg.closeAfter {
+		code, ok := closeCodes[msg.Type]
+		if !ok {
+			code = websocket.CloseNormalClosure
+		}
+		c.closeWith(code, msg.Type)
+		return false
+	}
+	return true
+}
+
+// connectionGone reports whether a write failed because the connection
+// was already closed, by us or the peer, rather than for an unexpected
+// reason worth a warning
+func connectionGone(err error) bool {
+	return errors.Is(err, websocket.ErrCloseSent) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// closeWith sends a close frame with code and reason, then closes the
+// client. WriteControl may be called concurrently with other writes, so
+// this is safe from any goroutine.
+func (c *Client) closeWith(code int, reason string) {
+	c.closeBy(code, reason, time.Now().Add(c.writeWait))
+}
+
+// closeBy is closeWith giving up on the close frame at deadline
+func (c *Client) closeBy(code int, reason string, deadline time.Time) {
+	closeMsg := websocket.FormatCloseMessage(code, reason)
+	c.Conn.WriteControl(websocket.CloseMessage, closeMsg, deadline)
+	c.Close()
+}
+
+// write sends a single frame to the client, holding the write lock so
+// concurrent senders never interleave on the same connection. The write
+// deadline keeps a stalled socket from blocking the writer forever.
+func (c *Client) write(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeWait)); err != nil {
+		return err
+	}
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+// heartbeat pings the client every pingPeriod until it is closed. A peer
+// that stops answering lets the read deadline expire, which ends
+// handleMessages and runs the normal cleanup.
+func heartbeat(client *Client) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.done:
+			return
+		case <-ticker.C:
+			if err := client.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(client.writeWait)); err != nil {
+				slog.Warn("error sending ping", "roomId", client.RoomID, "clientId", client.ID, "err", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"video-conference-app/signaltest"
+)
+
+// stalledConn is a connection whose peer has stopped reading: after the
+// websocket handshake, writes block until the write deadline passes, as
+// they would once the socket's buffers are full
+type stalledConn struct {
+	mu            sync.Mutex
+	handshaken    bool
+	writeDeadline time.Time
+	closeOnce     sync.Once
+	closed        chan struct{}
+}
+
+func newStalledConn() *stalledConn {
+	return &stalledConn{closed: make(chan struct{})}
+}
+
+func (c *stalledConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	if !c.handshaken {
+		c.handshaken = true
+		c.mu.Unlock()
+		return len(p), nil
+	}
+	deadline := c.writeDeadline
+	c.mu.Unlock()
+
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-expired:
+		return 0, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *stalledConn) Read(p []byte) (int, error) {
+	<-c.closed
+	return 0, net.ErrClosed
+}
+
+func (c *stalledConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *stalledConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return nil
+}
+
+func (c *stalledConn) SetDeadline(t time.Time) error     { return c.SetWriteDeadline(t) }
+func (c *stalledConn) SetReadDeadline(t time.Time) error { return nil }
+func (c *stalledConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+}
+func (c *stalledConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+func (c *stalledConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// hijackRecorder hands conn to the websocket upgrader as the request's
+// underlying connection
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (w hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
+
+// serveStalled connects clientID to roomID over a stalledConn, which it
+// returns
+func serveStalled(hub *Hub, roomID, clientID string) *stalledConn {
+	conn := newStalledConn()
+	query := url.Values{"roomId": {roomID}, "clientId": {clientID}, "username": {clientID}}
+	r := httptest.NewRequest("GET", "/ws?"+query.Encode(), nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	handleWebSocket(hub).ServeHTTP(hijackRecorder{httptest.NewRecorder(), conn}, r)
+	return conn
+}
+
+func TestStalledClientEvicted(t *testing.T) {
+	const wait = 200 * time.Millisecond
+	srv, hub := newTestServer(t, withWriteWait(wait))
+	alice := mustJoin(t, srv, "room-1", "alice")
+
+	// bob completes the handshake and then never reads anything, so his
+	// joined acknowledgement can't be written
+	start := time.Now()
+	conn := serveStalled(hub, "room-1", "bob")
+
+	// The stalled writer doesn't hold up the room
+	if joined := mustExpect(t, alice, "join"); joined.From != "bob" {
+		t.Fatalf("join from %q, want bob", joined.From)
+	}
+	if err := alice.Send(map[string]any{"type": "chat", "message": "anyone there?", "msgId": "m1"}); err != nil {
+		t.Fatal(err)
+	}
+	mustExpect(t, alice, "chat-ack")
+
+	if left := mustExpect(t, alice, "leave"); left.From != "bob" {
+		t.Fatalf("leave from %q, want bob", left.From)
+	}
+	if elapsed := time.Since(start); elapsed < wait {
+		t.Fatalf("bob evicted after %v, before the write deadline (%v) passed", elapsed, wait)
+	}
+	if !conn.isClosed() {
+		t.Fatal("stalled connection left open after eviction")
+	}
+	room, _ := hub.GetRoom("room-1")
+	if count := room.summary().ClientCount; count != 1 {
+		t.Fatalf("room has %d clients after eviction, want 1", count)
+	}
+}
+
+func TestSlowConsumerDoesNotStallBroadcasts(t *testing.T) {
+	const wait = time.Second
+	_, hub := newTestServer(t, withWriteWait(wait))
+
+	// bob is alone in the room and never reads, so every broadcast piles
+	// up in his send buffer until it overflows
+	conn := serveStalled(hub, "room-1", "bob")
+	waitFor(t, "bob to join", func() bool {
+		room, ok := hub.GetRoom("room-1")
+		return ok && room.summary().ClientCount == 1
+	})
+
+	// Overflowing the buffer must not write the close frame under the
+	// room's lock, which would hold each broadcast for up to writeWait
+	for i := 0; i < 2*sendBufferSize; i++ {
+		start := time.Now()
+		hub.broadcastToRoom("room-1", Message{Type: "chat", RoomID: "room-1", Text: fmt.Sprint("message ", i)})
+		if elapsed := time.Since(start); elapsed > wait/4 {
+			t.Fatalf("broadcast %d took %v with bob's buffer full", i, elapsed)
+		}
+	}
+
+	waitFor(t, "bob to be evicted", conn.isClosed)
+}
+
+// newBufferedClient is a client with nothing draining its send buffer,
+// for watching what Send queues
+func newBufferedClient(t *testing.T, size int) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &Client{ID: "bob", RoomID: "room-1", send: make(chan Message, size), ctx: ctx, cancel: cancel, done: ctx.Done()}
+}
+
+func TestSendDropsOnlyCandidatesUnderBackpressure(t *testing.T) {
+	c := newBufferedClient(t, 16)
+	for i := 0; i < 1000; i++ {
+		if err := c.Send(Message{Type: "ice-candidate", From: "alice"}); err != nil {
+			t.Fatalf("candidate %d: %v", i, err)
+		}
+		if i%250 == 0 {
+			if err := c.Send(Message{Type: "offer", From: "alice", CorrelationID: fmt.Sprint(i)}); err != nil {
+				t.Fatalf("offer after %d candidates: %v", i, err)
+			}
+		}
+	}
+	if err := c.Send(Message{Type: "answer", From: "alice"}); err != nil {
+		t.Fatalf("answer after the flood: %v", err)
+	}
+
+	close(c.send)
+	var offers, answers, candidates int
+	for msg := range c.send {
+		switch msg.Type {
+		case "offer":
+			offers++
+		case "answer":
+			answers++
+		case "ice-candidate":
+			candidates++
+		}
+	}
+	if offers != 4 || answers != 1 {
+		t.Fatalf("delivered %d offers and %d answers, want 4 and 1", offers, answers)
+	}
+	if candidates > cap(c.send)*3/4 {
+		t.Fatalf("%d candidates queued, want at most three quarters of the buffer", candidates)
+	}
+}
+
+func TestSendPreservesOrder(t *testing.T) {
+	srv, hub := newTestServer(t)
+	alice := mustJoin(t, srv, "room-1", "alice")
+	mustJoin(t, srv, "room-1", "bob")
+	mustExpect(t, alice, "join")
+
+	// Queue a mix of types straight onto alice's send buffer, as broadcasts
+	// from several goroutines would, and check they arrive as queued
+	room, _ := hub.GetRoom("room-1")
+	room.mu.Lock()
+	client := room.Clients["alice"]
+	room.mu.Unlock()
+	types := []string{"join", "offer", "ice-candidate", "chat", "answer", "leave"}
+	const n = 120
+	for i := 0; i < n; i++ {
+		msg := Message{Type: types[i%len(types)], From: "bob", RoomID: "room-1", CorrelationID: fmt.Sprint(i)}
+		if err := client.Send(msg); err != nil {
+			t.Fatalf("queueing message %d: %v", i, err)
+		}
+	}
+	for i := 0; i < n; {
+		msg, err := alice.Receive(testTimeout)
+		if err != nil {
+			t.Fatalf("after %d of %d messages: %v", i, n, err)
+		}
+		if msg.From != "bob" || msg.CorrelationID == "" {
+			continue
+		}
+		if want := fmt.Sprint(i); msg.CorrelationID != want {
+			t.Fatalf("message %d arrived as number %s, want in queue order", i, msg.CorrelationID)
+		}
+		i++
+	}
+}
+
+func TestKickedClientsLeaveNoGoroutines(t *testing.T) {
+	srv, hub := newTestServer(t)
+	alice := mustJoin(t, srv, "room-1", "alice")
+	baseline := runtime.NumGoroutine()
+
+	// Rooms hold eight, so kick in rounds
+	const rounds, perRound = 3, 7
+	for round := 0; round < rounds; round++ {
+		clients := make([]*signaltest.Client, perRound)
+		for i := range clients {
+			id := fmt.Sprintf("guest-%d-%d", round, i)
+			clients[i] = mustJoin(t, srv, "room-1", id)
+			if err := alice.Send(map[string]any{"type": "kick", "to": id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, c := range clients {
+			mustExpect(t, c, "kicked")
+			c.Close()
+		}
+	}
+
+	waitFor(t, "kicked clients' goroutines to exit", func() bool {
+		return runtime.NumGoroutine() <= baseline
+	})
+	room, _ := hub.GetRoom("room-1")
+	if count := room.summary().ClientCount; count != 1 {
+		t.Fatalf("room has %d clients after the kicks, want 1", count)
+	}
+}
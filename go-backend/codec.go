@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// messageCodec turns Messages into websocket frames and back. Everything
+// above the connection works on Message only; which codec a client gets
+// is decided by the subprotocol it negotiated, see codecFor.
+type messageCodec interface {
+	// frameType is the websocket message type frames are sent and
+	// expected as
+	frameType() int
+	marshal(msg Message) ([]byte, error)
+	unmarshal(data []byte, msg *Message) error
+	// invalid is the error reported for a frame that won't decode
+	invalid() *protocolError
+}
+
+// codecs maps each supported protocol to its wire format
+var codecs = map[string]messageCodec{
+	"vc-signal-v1":         jsonCodec{},
+	"vc-signal-v1+msgpack": msgpackCodec{},
+}
+
+// codecFor returns the codec for a negotiated protocol, JSON by default
+func codecFor(protocol string) messageCodec {
+	if c, ok := codecs[protocol]; ok {
+		return c
+	}
+	return jsonCodec{}
+}
+
+// jsonCodec is the default: one JSON object per text frame
+type jsonCodec struct{}
+
+func (jsonCodec) frameType() int { return websocket.TextMessage }
+
+func (jsonCodec) marshal(msg Message) ([]byte, error) { return json.Marshal(msg) }
+
+func (jsonCodec) unmarshal(data []byte, msg *Message) error { return json.Unmarshal(data, msg) }
+
+func (jsonCodec) invalid() *protocolError {
+	return &protocolError{"invalid-json", "message is not a valid JSON object"}
+}
+
+// msgpackCodec sends the same fields as jsonCodec, under the same names,
+// as a MessagePack map per binary frame. For small high-frequency
+// messages like ICE candidates it saves the JSON punctuation and quoting.
+// It goes through the JSON encoding so the two never drift apart.
+type msgpackCodec struct{}
+
+func (msgpackCodec) frameType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) marshal(msg Message) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, v)
+}
+
+func (msgpackCodec) unmarshal(data []byte, msg *Message) error {
+	v, err := decodeMsgpack(data)
+	if err != nil {
+		return err
+	}
+	if _, ok := v.(map[string]any); !ok {
+		return errMsgpackInvalid
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, msg)
+}
+
+func (msgpackCodec) invalid() *protocolError {
+	return &protocolError{"invalid-msgpack", "message is not a valid MessagePack map"}
+}
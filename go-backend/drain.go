@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+// draining is set by an admin before a rolling deploy: the instance turns
+// away new websockets and reports not-ready, while connections already
+// open carry on until their clients leave
+var draining atomic.Bool
+
+// connOpened counts a newly upgraded websocket
+func (h *Hub) connOpened() {
+	h.numConns.Add(1)
+}
+
+// connClosed gives back everything client was charged for when its
+// websocket was accepted
+func (h *Hub) connClosed(client *Client) {
+	h.releaseIP(client)
+	h.numConns.Add(-1)
+}
+
+// handleDrain reports drain status on GET, starts draining on POST and
+// cancels it on DELETE. connections counts every open websocket, including
+// clients in a waiting room; once it reaches zero the instance can be
+// terminated. Admin only.
+func handleDrain(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			writeJSONError(w, http.StatusForbidden, "Admin API key required")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if !draining.Swap(true) {
+				slog.Info("draining connections", "event", "drain-start", "connections", hub.numConns.Load())
+			}
+		case http.MethodDelete:
+			if draining.Swap(false) {
+				slog.Info("drain cancelled", "event", "drain-stop", "connections", hub.numConns.Load())
+			}
+		default:
+			writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"draining":    draining.Load(),
+			"connections": hub.numConns.Load(),
+		})
+	}
+}
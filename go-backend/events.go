@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// eventLogSize is how many join/leave/kick events each room keeps for
+// auditing
+var eventLogSize = envInt("EVENT_LOG_SIZE", 500)
+
+// RoomEvent is one entry in a room's audit trail
+type RoomEvent struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	ClientID string    `json:"clientId"`
+	Username string    `json:"username,omitempty"`
+}
+
+// logEventLocked appends to the room's audit trail, dropping the oldest
+// entry once it is full. The caller must hold r.mu.
+func (r *Room) logEventLocked(event, clientID, username string) {
+	e := RoomEvent{Time: time.Now(), Event: event, ClientID: clientID, Username: username}
+	if len(r.events) < eventLogSize {
+		r.events = append(r.events, e)
+		return
+	}
+	copy(r.events, r.events[1:])
+	r.events[len(r.events)-1] = e
+}
+
+// handleRoomEvents returns a room's audit trail. Like ending a room, it
+// requires the host token or the admin API key.
+func handleRoomEvents(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID := r.PathValue("roomId")
+		room, exists := hub.GetRoom(roomID)
+		if !exists {
+			writeJSONError(w, http.StatusNotFound, "Room not found")
+			return
+		}
+		if !isAdmin(r) && !secretEqual(bearerToken(r), room.HostToken) {
+			writeJSONError(w, http.StatusForbidden, "Not allowed to view this room's events")
+			return
+		}
+
+		room.mu.Lock()
+		events := make([]RoomEvent, len(room.events))
+		copy(events, room.events)
+		room.mu.Unlock()
+
+		writeJSON(w, http.StatusOK, map[string][]RoomEvent{"events": events})
+	}
+}
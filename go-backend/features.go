@@ -0,0 +1,78 @@
+package main
+
+import "net/http"
+
+// ServerFeatures tells clients what this instance supports and its
+// limits, so one frontend can adapt to servers configured differently
+type ServerFeatures struct {
+	Protocols []string `json:"protocols"`
+	// Auth is whether joining needs a token; StrictRooms whether rooms
+	// must be created through the API before anyone can join them
+	Auth        bool `json:"auth"`
+	StrictRooms bool `json:"strictRooms"`
+	// AssignedClientIDs means the server picks clientIds and ignores the
+	// client's own
+	AssignedClientIDs bool `json:"assignedClientIds"`
+	// DefaultSettings are the settings of rooms nobody configured
+	DefaultSettings RoomSettings `json:"defaultSettings"`
+
+	WaitingRoom   bool `json:"waitingRoom"`
+	BreakoutRooms bool `json:"breakoutRooms"`
+	Recording     bool `json:"recording"`
+	ScreenShare   bool `json:"screenShare"`
+	FileShare     bool `json:"fileShare"`
+	LinkedRooms   bool `json:"linkedRooms"`
+	Transcripts   bool `json:"transcripts"`
+	Compression   bool `json:"compression"`
+
+	Limits FeatureLimits `json:"limits"`
+}
+
+// FeatureLimits are the caps clients are most likely to run into
+type FeatureLimits struct {
+	MaxRoomClients         int   `json:"maxRoomClients"`
+	MaxMessageBytes        int64 `json:"maxMessageBytes"`
+	MaxSDPBytes            int   `json:"maxSdpBytes"`
+	MaxRelayPayloadBytes   int   `json:"maxRelayPayloadBytes"`
+	MaxFileShareBytes      int64 `json:"maxFileShareBytes"`
+	MaxWelcomeMessageChars int   `json:"maxWelcomeMessageChars"`
+	ChatHistorySize        int   `json:"chatHistorySize"`
+	ChatPerSecond          int   `json:"chatPerSecond"`
+	MessagesPerSecond      int   `json:"messagesPerSecond"`
+}
+
+// serverFeatures describes this instance's configuration
+func (h *Hub) serverFeatures() ServerFeatures {
+	return ServerFeatures{
+		Protocols:         supportedProtocols,
+		Auth:              !h.authDisabled,
+		StrictRooms:       strictRooms,
+		AssignedClientIDs: assignClientIDs,
+		DefaultSettings:   defaultRoomSettings(),
+		WaitingRoom:       true,
+		BreakoutRooms:     true,
+		Recording:         true,
+		ScreenShare:       true,
+		FileShare:         true,
+		LinkedRooms:       true,
+		Transcripts:       transcriptDir != "",
+		Compression:       compressionEnabled,
+		Limits: FeatureLimits{
+			MaxRoomClients:         maxRoomClients,
+			MaxMessageBytes:        maxMessageSize,
+			MaxSDPBytes:            maxSDPSize,
+			MaxRelayPayloadBytes:   maxRelayPayloadSize,
+			MaxFileShareBytes:      maxFileShareSize,
+			MaxWelcomeMessageChars: maxWelcomeMessageLength,
+			ChatHistorySize:        chatHistorySize,
+			ChatPerSecond:          chatRateLimitPerSecond,
+			MessagesPerSecond:      rateLimitPerSecond,
+		},
+	}
+}
+
+func handleFeatures(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, hub.serverFeatures())
+	}
+}
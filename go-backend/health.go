@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	startTime = time.Now()
+	// shuttingDown flips readiness off so load balancers stop routing new
+	// connections here while we drain
+	shuttingDown atomic.Bool
+)
+
+// handleHealthz reports liveness. It only reads atomic counters so it can
+// never block behind a busy room or hub lock.
+func handleHealthz(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status":      "ok",
+			"uptime":      time.Since(startTime).Round(time.Second).String(),
+			"rooms":       hub.numRooms.Load(),
+			"clients":     hub.numClients.Load(),
+			"connections": hub.numConns.Load(),
+		})
+	}
+}
+
+// handleReadyz reports whether this instance should receive new traffic
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if shuttingDown.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "shutting down"})
+		return
+	}
+	if draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
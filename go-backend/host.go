@@ -0,0 +1,45 @@
+package main
+
+import "log/slog"
+
+// transferHost hands the room to targetID at the host's request; the old
+// host stays on as a regular participant. The target must be a connected,
+// non-service member when room.mu is taken: one that has already left, or
+// whose connection is closing, is rejected instead of becoming a host
+// that is about to disappear.
+func (r *Room) transferHost(from *Client, targetID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Host != from.ID {
+		from.sendError("not-host", "only the host can transfer hosting")
+		return
+	}
+	target, exists := r.Clients[targetID]
+	if exists {
+		select {
+		case <-target.done:
+			exists = false
+		default:
+		}
+	}
+	if !exists {
+		from.sendError("peer-not-found", "no client "+targetID+" in this room")
+		return
+	}
+	if target == from {
+		return
+	}
+	if target.Service {
+		from.sendError("invalid-target", "service clients can't host")
+		return
+	}
+
+	r.Host = target.ID
+	slog.Info("host changed", "event", "host-changed", "roomId", r.ID, "clientId", r.Host, "by", from.ID)
+	msg := Message{Type: "host-changed", From: from.ID, RoomID: r.ID, Host: r.Host}
+	r.broadcastLocked(msg)
+	from.Send(msg)
+	// Waiting joiners now need the new host's decision
+	r.handoffPendingLocked()
+}
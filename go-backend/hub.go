@@ -0,0 +1,715 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Room stores information about connected clients
+type Room struct {
+	ID string
+	// InstanceID tells apart rooms that reuse an ID once the previous one
+	// has ended, e.g. in transcript file names and webhook events
+	InstanceID string
+	Clients    map[string]*Client
+	CreatedAt  time.Time
+	// numClients mirrors len(Clients) so stats can read it without mu
+	numClients atomic.Int64
+	// mu guards the room's mutable state. It ranks above Hub.mu, see Hub.
+	mu sync.Mutex
+
+	// Settings are the meeting's rules, guarded by mu
+	Settings RoomSettings
+
+	// LastActivity is updated on every join and message, guarded by mu
+	LastActivity time.Time
+
+	// ScreenSharer is the client currently sharing its screen, if any, and
+	// ScreenStreamID the MediaStream ID it advertised for that share
+	ScreenSharer   string
+	ScreenStreamID string
+
+	// Recording is set while the host has a recording running
+	Recording bool
+
+	// Locked keeps new participants out, see setLocked
+	Locked bool
+
+	// WelcomeMessage is shown to each joiner, see welcome.go
+	WelcomeMessage string
+
+	// Links are the rooms this room's Presenter is relayed into and
+	// LinkedFrom the rooms relayed into this one, see links.go
+	Links      map[string]bool
+	LinkedFrom map[string]bool
+	Presenter  string
+	// linkRequests are the rooms waiting for this room's host to
+	// accept-link them, keyed by ID
+	linkRequests map[string]*Room
+
+	// RaisedHands holds the IDs of clients with a raised hand, in the order
+	// they raised it
+	RaisedHands []string
+
+	// Host is the client ID allowed to moderate the room. The first joiner
+	// becomes host; when the host leaves the longest-present client takes over.
+	Host string
+
+	// bus relays broadcasts to other instances; nil without Redis
+	bus *redisBus
+	// webhooks reports the room's lifecycle; nil without WEBHOOK_URL
+	webhooks *webhookSender
+
+	// closed is set, under mu, once the room has been removed from its hub.
+	// Joiners holding a stale pointer must look the room up again.
+	closed bool
+
+	// chatHistory holds the most recent chat messages, oldest first, so
+	// late joiners can catch up
+	chatHistory []Message
+	// events is the bounded join/leave/kick audit trail, see logEventLocked
+	events []RoomEvent
+
+	// Pending holds clients waiting for the host to admit them while
+	// Settings.WaitingRoom is on
+	Pending map[string]*Client
+
+	// HostToken lets whoever created the room through the API manage it
+	// out of band (e.g. end it for everyone). Empty for lazily created rooms.
+	HostToken string
+
+	// PasswordHash is the bcrypt hash of the room password, or nil for
+	// rooms that anyone can join
+	PasswordHash []byte
+}
+
+// checkPassword reports whether password grants access to the room
+func (r *Room) checkPassword(password string) bool {
+	if r.PasswordHash == nil {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword(r.PasswordHash, []byte(password)) == nil
+}
+
+// recordChat appends msg to the chat history, evicting the oldest entry
+// once the buffer is full. The caller must hold r.mu.
+func (r *Room) recordChat(msg Message) {
+	if len(r.chatHistory) < chatHistorySize {
+		r.chatHistory = append(r.chatHistory, msg)
+		return
+	}
+	copy(r.chatHistory, r.chatHistory[1:])
+	r.chatHistory[len(r.chatHistory)-1] = msg
+}
+
+// nextHostLocked picks the client who has been in the room longest,
+// or "" if the room is empty. The caller must hold r.mu.
+func (r *Room) nextHostLocked() string {
+	var next *Client
+	for _, c := range r.Clients {
+		if c.Service {
+			continue
+		}
+		if next == nil || c.JoinedAt.Before(next.JoinedAt) {
+			next = c
+		}
+	}
+	if next == nil {
+		return ""
+	}
+	return next.ID
+}
+
+// kick disconnects targetID if from is the room's host; requests from
+// anyone else are ignored
+func (r *Room) kick(from *Client, targetID string) {
+	r.mu.Lock()
+	isHost := r.Host == from.ID
+	target, exists := r.Clients[targetID]
+	if isHost && exists && target != from {
+		r.logEventLocked("kick", targetID, target.Username)
+	}
+	r.mu.Unlock()
+
+	if !isHost {
+		slog.Warn("ignoring kick from non-host", "roomId", r.ID, "clientId", from.ID, "to", targetID)
+		return
+	}
+	if !exists || target == from {
+		return
+	}
+
+	slog.Info("client kicked", "event", "kick", "roomId", r.ID, "clientId", targetID, "by", from.ID)
+	target.SendAndClose(Message{Type: "kicked", From: from.ID, RoomID: r.ID})
+}
+
+// disconnect boots clientID, whether a member or still waiting for
+// admission, on behalf of an admin. It reports whether the client was
+// found; its normal cleanup announces the leave.
+func (r *Room) disconnect(clientID string) bool {
+	r.mu.Lock()
+	target, exists := r.Clients[clientID]
+	if !exists {
+		target, exists = r.Pending[clientID]
+	}
+	if exists {
+		r.logEventLocked("disconnected-by-admin", clientID, target.Username)
+	}
+	r.mu.Unlock()
+
+	if !exists {
+		return false
+	}
+	slog.Info("client disconnected by admin", "event", "disconnected-by-admin", "roomId", r.ID, "clientId", clientID)
+	target.SendAndClose(Message{Type: "disconnected-by-admin", RoomID: r.ID})
+	return true
+}
+
+// setMediaState records the mic/camera toggles in msg on client and tells
+// the rest of the room. The broadcast always carries both flags so peers
+// don't have to track partial updates.
+func (r *Room) setMediaState(client *Client, msg Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if msg.AudioEnabled != nil {
+		if *msg.AudioEnabled && !client.AudioEnabled && !r.Settings.GuestsCanUnmute && r.Host != client.ID {
+			client.sendError("unmute-not-allowed", "the host has disabled unmuting")
+			return
+		}
+		client.AudioEnabled = *msg.AudioEnabled
+	}
+	if msg.VideoEnabled != nil {
+		client.VideoEnabled = *msg.VideoEnabled
+	}
+	audio, video := client.AudioEnabled, client.VideoEnabled
+	r.broadcastLocked(Message{
+		Type:         "media-state",
+		From:         client.ID,
+		RoomID:       r.ID,
+		AudioEnabled: &audio,
+		VideoEnabled: &video,
+	})
+}
+
+// rename changes client's display name and tells the room. The name has
+// already passed validateMessage.
+func (r *Room) rename(client *Client, username string) {
+	username = strings.TrimSpace(username)
+
+	r.mu.Lock()
+	old := client.Username
+	client.Username = username
+	r.logEventLocked("rename", client.ID, username)
+	r.broadcastLocked(Message{Type: "username-changed", From: client.ID, RoomID: r.ID, Username: username})
+	r.mu.Unlock()
+
+	slog.Info("client renamed", "event", "rename", "roomId", r.ID, "clientId", client.ID, "from", old, "to", username)
+	r.bus.registerClient(client)
+}
+
+// stamped gives a server-originated message, such as join, leave or
+// host-changed, its timestamp. Messages read from clients already have one.
+func stamped(msg Message) Message {
+	if msg.Timestamp == nil {
+		now := time.Now()
+		msg.Timestamp = &now
+	}
+	return msg
+}
+
+// broadcastLocked sends msg to every client except the sender, here and on
+// other instances. The caller must hold r.mu.
+func (r *Room) broadcastLocked(msg Message) (failed int) {
+	msg = stamped(r.scopeLocked(msg))
+	failed = r.deliverLocked(msg)
+	r.bus.publishBroadcast(msg)
+	return failed
+}
+
+// deliverLocked sends msg to every local client except the sender,
+// returning how many copies couldn't be queued. The caller must hold r.mu.
+func (r *Room) deliverLocked(msg Message) (failed int) {
+	for _, client := range r.Clients {
+		// Don't send message back to sender
+		if client.ID == msg.From || !r.reachesLocked(msg, client) {
+			continue
+		}
+
+		if err := client.Send(msg); err != nil {
+			slog.Warn("error broadcasting message", "roomId", r.ID, "clientId", client.ID, "msgType", msg.Type, "correlationId", msg.CorrelationID, "err", err)
+			failed++
+		}
+	}
+	return failed
+}
+
+// touch records activity in the room so the janitor leaves it alone
+func (r *Room) touch() {
+	r.mu.Lock()
+	r.LastActivity = time.Now()
+	r.mu.Unlock()
+}
+
+// newRoom creates an empty room using the server-wide defaults
+func newRoom() *Room {
+	now := time.Now()
+	return &Room{
+		InstanceID:   randomString(16),
+		Clients:      make(map[string]*Client),
+		Pending:      make(map[string]*Client),
+		Links:        make(map[string]bool),
+		LinkedFrom:   make(map[string]bool),
+		linkRequests: make(map[string]*Room),
+		Settings:     defaultRoomSettings(),
+		CreatedAt:    now,
+		LastActivity: now,
+	}
+}
+
+// Hub owns the set of active rooms. Each Hub is independent, so several
+// can run in one process without sharing state.
+//
+// Lock order is Client.typingMu, then Room.mu, then Hub.mu, and at most one
+// Room.mu at a time. Code holding h.mu never takes a room's mu: it only
+// touches the RoomStore. Anything that must change the room and the hub
+// together, like removing a room, locks the room first and then calls a
+// helper such as detachLocked that takes h.mu inside.
+type Hub struct {
+	rooms RoomStore
+	// mu serializes compound operations on rooms, such as check-then-create
+	mu sync.Mutex
+	// authDisabled skips token checks for this hub's clients. It defaults
+	// to AUTH_DISABLED; see withAuthDisabled.
+	authDisabled bool
+	// writeWait bounds every write to this hub's clients; see
+	// withWriteWait
+	writeWait time.Duration
+
+	// bus shares rooms with other instances when Redis is configured
+	bus *redisBus
+	// transcripts writes chat to disk when TRANSCRIPT_DIR is set
+	transcripts *transcriptWriter
+	// webhooks reports room events when WEBHOOK_URL is set
+	webhooks *webhookSender
+	// ipConns enforces maxConnectionsPerIP
+	ipConns *ipConnCounter
+
+	// ctx is the parent of every client's context; shutdown cancels it
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Running totals readable without taking mu, for health checks
+	numRooms   atomic.Int64
+	numClients atomic.Int64
+	// numConns counts open websockets, admitted or not, see drain.go
+	numConns atomic.Int64
+}
+
+// hubOption changes a Hub's defaults as NewHub creates it
+type hubOption func(*Hub)
+
+// withAuthDisabled makes the hub trust client-supplied identities, as
+// AUTH_DISABLED does, without touching the process-wide setting; tests
+// use it to serve a hub without minting tokens
+func withAuthDisabled() hubOption {
+	return func(h *Hub) { h.authDisabled = true }
+}
+
+// withWriteWait replaces writeWait for the hub's clients, so tests can
+// watch a stalled client get evicted without waiting ten seconds
+func withWriteWait(d time.Duration) hubOption {
+	return func(h *Hub) { h.writeWait = d }
+}
+
+// NewHub creates an empty hub backed by an in-memory RoomStore
+func NewHub(opts ...hubOption) *Hub {
+	return NewHubWithStore(newMemoryRoomStore(), opts...)
+}
+
+// NewHubWithStore creates a hub that keeps its rooms in store
+func NewHubWithStore(store RoomStore, opts ...hubOption) *Hub {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &Hub{rooms: store, ipConns: newIPConnCounter(), ctx: ctx, cancel: cancel, authDisabled: authDisabled, writeWait: writeWait}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// shutdown closes every client of the hub, including ones not in a room
+// yet, and any that connect afterwards
+func (h *Hub) shutdown() {
+	h.cancel()
+}
+
+// addRooms adjusts the room count and its metric
+func (h *Hub) addRooms(delta int64) {
+	h.numRooms.Add(delta)
+	roomsActive.Add(float64(delta))
+}
+
+// addClients adjusts the connected client count and its metric
+func (h *Hub) addClients(delta int64) {
+	h.numClients.Add(delta)
+	clientsConnected.Add(float64(delta))
+}
+
+// reserveClient counts one more connected client unless that would exceed
+// maxTotalClients, in which case it reports false
+func (h *Hub) reserveClient() bool {
+	for {
+		n := h.numClients.Load()
+		if n >= int64(maxTotalClients) {
+			return false
+		}
+		if h.numClients.CompareAndSwap(n, n+1) {
+			clientsConnected.Inc()
+			return true
+		}
+	}
+}
+
+var (
+	// errRoomExists is returned when a requested room name is already taken
+	errRoomExists = errors.New("room already exists")
+	// errServerAtCapacity is returned when a server-wide limit is reached
+	errServerAtCapacity = errors.New("server at capacity")
+	// errRoomNotFound is returned for joins to unknown rooms in strict mode
+	errRoomNotFound = errors.New("room not found")
+)
+
+// CreateRoom registers room under name, or if name is empty under a freshly
+// generated ID (retrying if the ID is already taken), and returns the ID.
+// A name that is already taken fails with errRoomExists, and creating a
+// room beyond maxTotalRooms with errServerAtCapacity.
+func (h *Hub) CreateRoom(room *Room, name string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.rooms.Count() >= maxTotalRooms {
+		return "", errServerAtCapacity
+	}
+
+	room.bus = h.bus
+	room.webhooks = h.webhooks
+	room.ID = name
+	if name == "" {
+		room.ID = generateRoomID()
+	}
+	err := h.rooms.Create(room)
+	for name == "" && errors.Is(err, errRoomExists) {
+		room.ID = generateRoomID()
+		err = h.rooms.Create(room)
+	}
+	if err != nil {
+		return "", err
+	}
+	h.addRooms(1)
+	h.webhooks.emit(webhookRoomCreated, room, "")
+	return room.ID, nil
+}
+
+// GetRoom looks up a room by ID
+func (h *Hub) GetRoom(roomID string) (*Room, bool) {
+	return h.rooms.Get(roomID)
+}
+
+// getOrCreateRoom returns the named room, creating it if it doesn't exist.
+// Creating a room beyond maxTotalRooms fails with errServerAtCapacity.
+func (h *Hub) getOrCreateRoom(roomID string) (*Room, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if room, exists := h.rooms.Get(roomID); exists {
+		return room, nil
+	}
+	if h.rooms.Count() >= maxTotalRooms {
+		return nil, errServerAtCapacity
+	}
+	room := newRoom()
+	room.ID = roomID
+	room.bus = h.bus
+	room.webhooks = h.webhooks
+	if err := h.rooms.Create(room); err != nil {
+		return nil, err
+	}
+	h.addRooms(1)
+	h.webhooks.emit(webhookRoomCreated, room, "")
+	return room, nil
+}
+
+// joinableRoom returns the room a websocket client asked for. Rooms are
+// created on first join unless strictRooms is set, in which case unknown
+// rooms fail with errRoomNotFound.
+func (h *Hub) joinableRoom(roomID string) (*Room, error) {
+	if !strictRooms {
+		return h.getOrCreateRoom(roomID)
+	}
+	room, exists := h.GetRoom(roomID)
+	if !exists {
+		return nil, errRoomNotFound
+	}
+	return room, nil
+}
+
+// RemoveRoom deletes a room from the hub and marks it closed. Both happen
+// under room.mu, so a joiner holding the room can't slip in after it has
+// left the hub but before it is marked closed.
+func (h *Hub) RemoveRoom(roomID string) {
+	room, exists := h.GetRoom(roomID)
+	if !exists {
+		return
+	}
+	room.mu.Lock()
+	h.detachLocked(room)
+	room.mu.Unlock()
+}
+
+// removeClient takes client out of room and, if that leaves the room
+// empty, removes the room from the hub. The delete, the emptiness check and
+// the removal all happen under room.mu so concurrent leaves can't both
+// decide to (or both fail to) tear the room down. Lock order is room.mu
+// then h.mu.
+//
+// When others remain, they are told about the departure (and anything it
+// changed, like a new host) in the same critical section, so nobody can
+// join in between and miss it.
+//
+// removed is false if client had already been replaced in the room, e.g.
+// by a reconnect using the same ID.
+func (h *Hub) removeClient(room *Room, client *Client) (removed, empty bool) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.removePendingLocked(client) || room.Clients[client.ID] != client {
+		return false, false
+	}
+	delete(room.Clients, client.ID)
+	room.numClients.Add(-1)
+	if len(room.Clients) == 0 {
+		room.webhooks.emit(webhookRoomEnded, room, client.ID)
+		room.Host = ""
+		room.handoffPendingLocked()
+		if !strictRooms {
+			h.detachLocked(room)
+			return true, true
+		}
+		// Strict rooms can't be recreated by joining, so they outlive their
+		// participants until deleted or reaped by the janitor. Nobody is
+		// left to tell, but the audit trail still records the leave.
+		if !client.Service {
+			room.logEventLocked("leave", client.ID, client.Username)
+		}
+		room.clearScreenShareLocked(client)
+		room.clearRaisedHandLocked(client)
+		// A recording can't run in an empty room; the next joiner mustn't
+		// see one
+		if room.Recording {
+			room.Recording = false
+			room.webhooks.emit(webhookRecordingStopped, room, client.ID)
+		}
+		// Otherwise nobody could ever get back in
+		room.Locked = false
+		room.LastActivity = time.Now()
+		return true, false
+	}
+
+	// Notify others that peer has left; service clients leave silently
+	if !client.Service {
+		room.logEventLocked("leave", client.ID, client.Username)
+		room.broadcastLocked(Message{Type: "leave", From: client.ID, RoomID: room.ID, Username: client.Username})
+	}
+	room.clearScreenShareLocked(client)
+	room.clearRaisedHandLocked(client)
+	if room.Host == client.ID {
+		room.Host = room.nextHostLocked()
+		slog.Info("host changed", "event", "host-changed", "roomId", room.ID, "clientId", room.Host)
+		room.broadcastLocked(Message{Type: "host-changed", RoomID: room.ID, Host: room.Host})
+		room.handoffPendingLocked()
+	}
+	return true, false
+}
+
+// CloseRoom ends a room for everyone: it is removed from the hub, then every
+// client is sent a room-closed notice followed by a close frame. It
+// reports whether the room existed. Clients are closed after room.mu is
+// released, so their own cleanup in handleMessages can take it freely.
+func (h *Hub) CloseRoom(roomID string) bool {
+	room, exists := h.GetRoom(roomID)
+	if !exists {
+		return false
+	}
+
+	room.mu.Lock()
+	h.detachLocked(room)
+	clients := make([]*Client, 0, len(room.Clients)+len(room.Pending))
+	for _, client := range room.Clients {
+		clients = append(clients, client)
+	}
+	for id, client := range room.Pending {
+		delete(room.Pending, id)
+		clients = append(clients, client)
+	}
+	room.mu.Unlock()
+
+	for _, client := range clients {
+		client.SendAndClose(Message{Type: "room-closed", RoomID: roomID})
+	}
+	return true
+}
+
+// detachLocked marks room closed and removes it from the hub if it is
+// still registered there. It reports whether it was. The caller must hold
+// room.mu; h.mu is taken inside, matching the room.mu then h.mu order.
+func (h *Hub) detachLocked(room *Room) bool {
+	room.closed = true
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if current, exists := h.rooms.Get(room.ID); !exists || current != room {
+		return false
+	}
+	h.rooms.Delete(room.ID)
+	h.addRooms(-1)
+	h.webhooks.emit(webhookRoomDestroyed, room, "")
+	return true
+}
+
+// ListRooms returns a snapshot of all active rooms
+func (h *Hub) ListRooms() []*Room {
+	return h.rooms.List()
+}
+
+// forwardMessage delivers msg to the single client named in msg.To, which
+// may be connected to another instance. It reports whether msg was handed
+// to that client, which is false if it is not in the room or disconnected
+// before the message could be queued.
+func (h *Hub) forwardMessage(msg Message) bool {
+	room, exists := h.GetRoom(msg.RoomID)
+	if !exists {
+		return false
+	}
+
+	room.mu.Lock()
+	targetClient, exists := room.Clients[msg.To]
+	if exists && !room.sameBreakoutLocked(msg.From, targetClient) {
+		// Peers in different breakouts can't reach each other
+		room.mu.Unlock()
+		return false
+	}
+	room.mu.Unlock()
+
+	if !exists {
+		if h.bus.isRemote(msg.RoomID, msg.To) {
+			h.bus.publishForward(msg)
+			return true
+		}
+		return false
+	}
+
+	if err := targetClient.Send(msg); err != nil {
+		slog.Warn("error forwarding message", "roomId", msg.RoomID, "clientId", msg.To, "from", msg.From, "msgType", msg.Type, "correlationId", msg.CorrelationID, "err", err)
+		return false
+	}
+	slog.Debug("message forwarded", "roomId", msg.RoomID, "clientId", msg.To, "from", msg.From, "msgType", msg.Type, "correlationId", msg.CorrelationID)
+	return true
+}
+
+// forwardToMany delivers a copy of msg to every client in msg.ToMany,
+// resolving all local recipients under a single lock. It returns the IDs
+// that are in neither this nor another instance's part of the room, or
+// that disconnected before their copy could be queued.
+func (h *Hub) forwardToMany(msg Message) (missing []string) {
+	room, exists := h.GetRoom(msg.RoomID)
+	if !exists {
+		return msg.ToMany
+	}
+
+	recipients := msg.ToMany
+	msg.ToMany = nil
+	targets := make(map[string]*Client, len(recipients))
+	room.mu.Lock()
+	for _, id := range recipients {
+		if c, ok := room.Clients[id]; ok && room.sameBreakoutLocked(msg.From, c) {
+			targets[id] = c
+		}
+	}
+	room.mu.Unlock()
+
+	for _, id := range recipients {
+		m := msg
+		m.To = id
+		target, ok := targets[id]
+		switch {
+		case ok:
+			if err := target.Send(m); err != nil {
+				slog.Warn("error forwarding message", "roomId", m.RoomID, "clientId", id, "from", m.From, "msgType", m.Type, "correlationId", m.CorrelationID, "err", err)
+				missing = append(missing, id)
+			}
+		case h.bus.isRemote(m.RoomID, id):
+			h.bus.publishForward(m)
+		default:
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+func (h *Hub) broadcastToRoom(roomID string, msg Message) {
+	room, exists := h.GetRoom(roomID)
+	if !exists {
+		return
+	}
+
+	room.mu.Lock()
+	room.broadcastLocked(msg)
+	room.mu.Unlock()
+}
+
+// broadcastChat records a chat message in the room history and broadcasts
+// it in the same critical section, so a concurrent joiner sees it exactly
+// once: either in its history replay or live. Chat in rooms with
+// transcripts on is also queued for the transcript. It returns how many
+// recipients the message couldn't be queued for.
+func (h *Hub) broadcastChat(room *Room, msg Message) (failed int) {
+	room.mu.Lock()
+	msg = stamped(room.scopeLocked(msg))
+	room.recordChat(msg)
+	failed = room.broadcastLocked(msg)
+	if h.transcripts != nil && room.Settings.Transcript && msg.Type == "chat" {
+		h.transcripts.append(room, msg)
+	}
+	room.mu.Unlock()
+	return failed
+}
+
+// Helper function to generate a random room ID
+func generateRoomID() string {
+	return "room-" + randomString(8)
+}
+
+// randomString returns a string of the given length drawn uniformly from
+// an alphanumeric charset using crypto/rand.
+func randomString(length int) string {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	max := big.NewInt(int64(len(charset)))
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic("crypto/rand unavailable: " + err.Error())
+		}
+		b[i] = charset[n.Int64()]
+	}
+	return string(b)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGenerateRoomIDUnique(t *testing.T) {
+	const n = 1000
+	seen := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		id := generateRoomID()
+		if !strings.HasPrefix(id, "room-") || len(id) != len("room-")+8 {
+			t.Fatalf("generateRoomID() = %q, want room- and 8 characters", id)
+		}
+		if _, err := validateRoomID(id); err != nil {
+			t.Fatalf("generateRoomID() = %q, which validateRoomID rejects: %v", id, err)
+		}
+		if seen[id] {
+			t.Fatalf("generateRoomID() repeated %q after %d calls", id, i)
+		}
+		seen[id] = true
+	}
+}
+
+func TestRapidConnectDisconnect(t *testing.T) {
+	srv, hub := newTestServer(t)
+	const rooms, workers, iterations = 4, 8, 15
+
+	// Each kept room has a resident that stays for the whole test; the
+	// churn rooms should be gone once everyone has left them
+	for i := 0; i < rooms; i++ {
+		mustJoin(t, srv, fmt.Sprintf("keep-%d", i), fmt.Sprintf("resident-%d", i))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				roomID := fmt.Sprintf("churn-%d", w%rooms)
+				if i%2 == 1 {
+					roomID = fmt.Sprintf("keep-%d", w%rooms)
+				}
+				c, err := srv.Join(roomID, fmt.Sprintf("worker-%d-%d", w, i))
+				if err != nil {
+					errs <- err
+					return
+				}
+				c.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "churn rooms to be removed", func() bool {
+		return hub.numRooms.Load() == rooms && len(hub.ListRooms()) == rooms
+	})
+	for _, room := range hub.ListRooms() {
+		if !strings.HasPrefix(room.ID, "keep-") {
+			t.Fatalf("room %s outlived its clients", room.ID)
+		}
+		waitFor(t, room.ID+" to hold only its resident", func() bool {
+			return room.summary().ClientCount == 1
+		})
+	}
+}
+
+// TestConcurrentJoinLeaveBroadcast shakes out lock-order problems between
+// Client.typingMu, room.mu and h.mu; run it with -race. A deadlock shows
+// up as the workers not finishing.
+func TestConcurrentJoinLeaveBroadcast(t *testing.T) {
+	srv, hub := newTestServer(t)
+	const rooms, workers, iterations = 3, 12, 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers+1)
+	stop := make(chan struct{})
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			roomID := fmt.Sprintf("stress-%d", w%rooms)
+			peer := fmt.Sprintf("worker-%d", (w+rooms)%workers)
+			for i := 0; i < iterations; i++ {
+				c, err := srv.Join(roomID, fmt.Sprintf("worker-%d", w))
+				if err != nil {
+					errs <- err
+					return
+				}
+				c.Send(map[string]any{"type": "chat", "message": fmt.Sprint("hello ", i)})
+				c.Send(map[string]any{"type": "typing", "isTyping": true})
+				c.Send(map[string]any{"type": "media-state", "audioEnabled": i%2 == 0, "videoEnabled": true})
+				c.Send(map[string]any{"type": "offer", "to": peer, "sdp": map[string]string{"type": "offer", "sdp": testSDP}})
+				c.Close()
+			}
+		}()
+	}
+	// Meanwhile take room locks from outside the rooms' own goroutines:
+	// list rooms, broadcast into one and keep removing another out from
+	// under its members
+	go func() {
+		for {
+			select {
+			case <-stop:
+				errs <- nil
+				return
+			default:
+			}
+			resp, err := http.Get(srv.URL + "/api/rooms?detailed=true")
+			if err != nil {
+				errs <- err
+				return
+			}
+			resp.Body.Close()
+			hub.broadcastToRoom("stress-0", Message{Type: "chat", RoomID: "stress-0", Text: "tick"})
+			hub.RemoveRoom("stress-1")
+		}
+	}()
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(20 * time.Second):
+		t.Fatal("workers did not finish; likely a deadlock")
+	}
+	close(stop)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "every room to be removed", func() bool {
+		return hub.numRooms.Load() == 0 && len(hub.ListRooms()) == 0
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ICEServer mirrors the RTCIceServer dictionary expected by browsers
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+var (
+	stunURLs = envList("STUN_URLS", []string{"stun:stun.l.google.com:19302"})
+	turnURLs = envList("TURN_URLS", nil)
+
+	// turnSecret enables ephemeral TURN credentials (coturn's
+	// use-auth-secret / REST API scheme). When unset, the static
+	// TURN_USERNAME and TURN_CREDENTIAL are handed out instead.
+	turnSecret     = envString("TURN_SECRET", "")
+	turnUsername   = envString("TURN_USERNAME", "")
+	turnCredential = envString("TURN_CREDENTIAL", "")
+	turnTTL        = time.Duration(envInt("TURN_TTL_SECONDS", 86400)) * time.Second
+)
+
+// handleICEServers hands out the STUN and TURN servers to use. Like /ws it
+// requires a user token, or the admin key for service peers, so the TURN
+// server can't be used as an open relay; ephemeral TURN usernames name
+// the token's subject.
+func handleICEServers(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		// Without auth there is no identity to put in the TURN username
+		userID := randomString(12)
+		if !hub.authDisabled && !isAdmin(r) {
+			claims, err := authenticate(r)
+			if err != nil {
+				slog.Warn("rejected ice-servers token", "err", err)
+				writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+			userID = claims.Subject
+		}
+
+		servers := make([]ICEServer, 0, 2)
+		if len(stunURLs) > 0 {
+			servers = append(servers, ICEServer{URLs: stunURLs})
+		}
+		if len(turnURLs) > 0 {
+			turn := ICEServer{URLs: turnURLs}
+			if turnSecret != "" {
+				turn.Username, turn.Credential = turnCredentials(userID, time.Now().Add(turnTTL))
+			} else {
+				turn.Username, turn.Credential = turnUsername, turnCredential
+			}
+			servers = append(servers, turn)
+		}
+
+		writeJSON(w, http.StatusOK, map[string][]ICEServer{"iceServers": servers})
+	}
+}
+
+// turnCredentials builds a time-limited TURN username/credential pair: the
+// username is "<expiry>:<userID>" and the credential is the base64
+// HMAC-SHA1 of the username keyed with the shared secret, which is what
+// coturn verifies.
+func turnCredentials(userID string, expiry time.Time) (string, string) {
+	username := strconv.FormatInt(expiry.Unix(), 10) + ":" + userID
+	mac := hmac.New(sha1.New, []byte(turnSecret))
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
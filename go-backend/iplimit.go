@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+var (
+	// maxConnectionsPerIP caps the websockets one address may hold open
+	maxConnectionsPerIP = envInt("MAX_CONNECTIONS_PER_IP", 50)
+	// trustedProxies are the addresses (or CIDRs) of reverse proxies whose
+	// X-Forwarded-For header is believed
+	trustedProxies = parsePrefixes("TRUSTED_PROXIES", envList("TRUSTED_PROXIES", nil))
+	// connectionLimitAllowlist are addresses (or CIDRs), such as an office
+	// NAT or a load tester, exempt from maxConnectionsPerIP
+	connectionLimitAllowlist = parsePrefixes("CONNECTION_LIMIT_ALLOWLIST", envList("CONNECTION_LIMIT_ALLOWLIST", nil))
+)
+
+// parsePrefixes parses a list of IPs and CIDRs, skipping (and warning
+// about) entries that are neither
+func parsePrefixes(key string, list []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		if addr, err := netip.ParseAddr(s); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			slog.Warn("ignoring invalid address", "key", key, "value", s)
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address a request came from. Behind a trusted
+// proxy that is the rightmost X-Forwarded-For entry that isn't itself a
+// trusted proxy, since anything left of it could have been forged by the
+// client. An invalid address is returned if nothing parses.
+func clientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	addr = addr.Unmap()
+	if !containsAddr(trustedProxies, addr) {
+		return addr
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !containsAddr(trustedProxies, addr) {
+			break
+		}
+	}
+	return addr
+}
+
+// ipConnCounter tracks open websockets per remote address
+type ipConnCounter struct {
+	mu     sync.Mutex
+	counts map[netip.Addr]int
+}
+
+func newIPConnCounter() *ipConnCounter {
+	return &ipConnCounter{counts: make(map[netip.Addr]int)}
+}
+
+// acquire counts one more connection from addr, or reports false if addr
+// is already at maxConnectionsPerIP
+func (c *ipConnCounter) acquire(addr netip.Addr) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts[addr] >= maxConnectionsPerIP {
+		return false
+	}
+	c.counts[addr]++
+	return true
+}
+
+// release gives back a connection taken with acquire
+func (c *ipConnCounter) release(addr netip.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts[addr] <= 1 {
+		delete(c.counts, addr)
+		return
+	}
+	c.counts[addr]--
+}
+
+// releaseIP gives back the connection client was charged for, if any
+func (h *Hub) releaseIP(client *Client) {
+	if client.countedIP.IsValid() {
+		h.ipConns.release(client.countedIP)
+	}
+}
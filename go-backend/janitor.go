@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// roomIdleTimeout is how long a room may sit empty before the janitor
+	// removes it. This catches rooms created via the API that nobody joins.
+	roomIdleTimeout = time.Duration(envInt("ROOM_IDLE_TIMEOUT_SECONDS", 600)) * time.Second
+	janitorInterval = time.Duration(envInt("ROOM_JANITOR_INTERVAL_SECONDS", 60)) * time.Second
+	// clientIdleTimeout disconnects participants who have sent nothing but
+	// echo pings for this long. Unlike the heartbeat, which catches dead
+	// TCP connections, this targets connected but inactive users. Zero,
+	// the default, disables it.
+	clientIdleTimeout = time.Duration(envInt("CLIENT_IDLE_TIMEOUT_SECONDS", 0)) * time.Second
+)
+
+// runJanitor periodically reaps idle rooms until ctx is canceled
+func (h *Hub) runJanitor(ctx context.Context) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.collectRooms(now, roomIdleTimeout, "janitor")
+			if clientIdleTimeout > 0 {
+				if n := h.reapIdleClients(now, clientIdleTimeout); n > 0 {
+					slog.Info("disconnected idle clients", "event", "idle-timeout", "count", n)
+				}
+			}
+		}
+	}
+}
+
+// collectRooms runs one idle-room sweep, for the janitor or an admin's
+// POST /api/admin/gc, and records it in the logs and metrics under
+// trigger. It returns how many rooms were removed.
+func (h *Hub) collectRooms(now time.Time, idle time.Duration, trigger string) int {
+	n := h.reapIdleRooms(now, idle)
+	roomGCRunsTotal.WithLabelValues(trigger).Inc()
+	roomGCLastRun.SetToCurrentTime()
+	if n > 0 {
+		roomsReapedTotal.WithLabelValues(trigger).Add(float64(n))
+		slog.Info("reaped idle rooms", "event", "room-reaped", "count", n, "trigger", trigger)
+	}
+	return n
+}
+
+// handleGC sweeps idle rooms immediately, the way the janitor would on its
+// next tick. idleSeconds overrides ROOM_IDLE_TIMEOUT_SECONDS for this
+// sweep; 0 removes every empty room, which clears ghosts left behind by an
+// incident. Admin only.
+func handleGC(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			writeJSONError(w, http.StatusForbidden, "Admin API key required")
+			return
+		}
+
+		idle := roomIdleTimeout
+		if v := r.URL.Query().Get("idleSeconds"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeJSONError(w, http.StatusBadRequest, "idleSeconds must be a non-negative integer")
+				return
+			}
+			idle = time.Duration(n) * time.Second
+		}
+
+		reaped := hub.collectRooms(time.Now(), idle, "manual")
+		writeJSON(w, http.StatusOK, map[string]any{
+			"reaped": reaped,
+			"rooms":  len(hub.ListRooms()),
+		})
+	}
+}
+
+// reapIdleRooms removes every room that is empty and has seen no activity
+// for at least idle, returning how many were removed. The emptiness check
+// and the closed flag are set under room.mu, so a joiner racing the reap
+// either gets in first (and the room survives) or sees the room closed and
+// looks it up again.
+func (h *Hub) reapIdleRooms(now time.Time, idle time.Duration) int {
+	reaped := 0
+	for _, room := range h.ListRooms() {
+		room.mu.Lock()
+		if room.closed || len(room.Clients) > 0 || now.Sub(room.LastActivity) < idle {
+			room.mu.Unlock()
+			continue
+		}
+		if h.detachLocked(room) {
+			reaped++
+		}
+		room.mu.Unlock()
+	}
+	return reaped
+}
+
+// reapIdleClients disconnects every room member that has sent no message
+// for at least idle, telling it why first, and returns how many were
+// disconnected. Their normal cleanup in handleMessages announces the leave.
+func (h *Hub) reapIdleClients(now time.Time, idle time.Duration) int {
+	var idleClients []*Client
+	for _, room := range h.ListRooms() {
+		room.mu.Lock()
+		for _, client := range room.Clients {
+			if !client.Service && now.Sub(client.lastSeenAt()) >= idle {
+				idleClients = append(idleClients, client)
+			}
+		}
+		room.mu.Unlock()
+	}
+
+	for _, client := range idleClients {
+		slog.Info("disconnecting idle client", "event", "idle-timeout", "roomId", client.RoomID, "clientId", client.ID)
+		client.SendAndClose(Message{Type: "idle-timeout", RoomID: client.RoomID})
+	}
+	return len(idleClients)
+}
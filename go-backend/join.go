@@ -0,0 +1,357 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"time"
+)
+
+// joinResult carries what admitLocked gathered under the room lock over
+// to finishJoin, which does the slower work after it is released
+type joinResult struct {
+	previous *Client // connection replaced by a reconnect, if any
+	// duplicates are the user's other sessions in the room
+	duplicates []*Client
+	event      string // join or reconnect
+	username   string
+}
+
+// enterRoom puts a freshly upgraded client into room, or into the waiting
+// room if the room requires admission. It returns the room the client
+// ended up in (rooms torn down mid-join are looked up again), or nil if
+// the client was turned away.
+func (h *Hub) enterRoom(room *Room, client *Client) *Room {
+	// Fetched up front: admitLocked snapshots the room under its lock and
+	// mustn't wait on Redis there
+	remote := h.bus.remoteParticipants(room.ID)
+
+	room.mu.Lock()
+	for room.closed {
+		// The last client left and the room was torn down while we were
+		// upgrading; join (or lazily recreate) the current one instead
+		room.mu.Unlock()
+		var err error
+		if room, err = h.joinableRoom(room.ID); errors.Is(err, errRoomNotFound) {
+			client.SendAndClose(Message{Type: "room-not-found", RoomID: client.RoomID})
+			return nil
+		} else if err != nil {
+			client.SendAndClose(Message{Type: "server-at-capacity", RoomID: client.RoomID})
+			return nil
+		}
+		room.mu.Lock()
+	}
+
+	previous, rejoin := room.Clients[client.ID]
+	if client.Username == "" {
+		// A guest reconnecting keeps the name it was given
+		if rejoin {
+			client.Username = previous.Username
+		} else {
+			client.Username = room.guestNameLocked()
+		}
+	}
+
+	if room.Locked && !rejoin && !client.Service {
+		room.mu.Unlock()
+		client.SendAndClose(Message{Type: "room-locked", RoomID: room.ID})
+		return nil
+	}
+
+	// Reconnecting participants and the very first joiner (who becomes
+	// host) skip the waiting room
+	if room.Settings.WaitingRoom && !rejoin && !client.Service && room.Host != "" {
+		room.queueLocked(client)
+		room.mu.Unlock()
+		return room
+	}
+
+	res, rejected := h.admitLocked(room, client, remote)
+	room.mu.Unlock()
+	if rejected != "" {
+		client.SendAndClose(Message{Type: rejected, RoomID: room.ID})
+		return nil
+	}
+	h.finishJoin(room, client, res)
+	return room
+}
+
+// guestNameLocked picks a friendly name like "Guest-1234" for a client
+// that joined without one, unique among the room's members and waiting
+// clients. The caller must hold r.mu.
+func (r *Room) guestNameLocked() string {
+	taken := make(map[string]bool, len(r.Clients)+len(r.Pending))
+	for _, c := range r.Clients {
+		taken[c.Username] = true
+	}
+	for _, c := range r.Pending {
+		taken[c.Username] = true
+	}
+	for {
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			panic("crypto/rand unavailable: " + err.Error())
+		}
+		if name := fmt.Sprintf("Guest-%04d", n.Int64()); !taken[name] {
+			return name
+		}
+	}
+}
+
+// admitLocked makes client a member of room. The capacity check, the
+// insertion, queueing the joiner's snapshot and announcing it share one
+// critical section, so concurrent joins can't overfill the room and no
+// broadcast can reach the joiner ahead of its joined acknowledgement or
+// contradict its room-state: everything broadcast after the snapshot is
+// queued behind it. remote are the room's members on other instances.
+// A client reusing the ID of a current member is treated as that member
+// reconnecting, unless they authenticated as different users. If the
+// client can't be admitted it returns the message type to reject it with:
+// duplicate-id, room-full, or server-at-capacity once maxTotalClients is
+// reached. The caller must hold room.mu.
+func (h *Hub) admitLocked(room *Room, client *Client, remote []Participant) (res joinResult, rejected string) {
+	previous, rejoin := room.Clients[client.ID]
+	if rejoin && previous.UserID != client.UserID {
+		slog.Warn("rejected duplicate client id", "event", "duplicate-id", "roomId", room.ID, "clientId", client.ID, "userId", client.UserID, "existingUserId", previous.UserID)
+		return res, "duplicate-id"
+	}
+	if !rejoin && !client.Service && room.participantCountLocked() >= room.Settings.MaxClients {
+		return res, "room-full"
+	}
+	if !rejoin && !h.reserveClient() {
+		return res, "server-at-capacity"
+	}
+	if rejoin {
+		// Same participant on a new connection: keep its identity and
+		// state so peers see a seamless resume
+		client.JoinedAt = previous.JoinedAt
+		client.AudioEnabled = previous.AudioEnabled
+		client.VideoEnabled = previous.VideoEnabled
+		client.Breakout = previous.Breakout
+		res.previous = previous
+	}
+	room.Clients[client.ID] = client
+	if !rejoin {
+		room.numClients.Add(1)
+		if len(room.Clients) == 1 {
+			room.webhooks.emit(webhookRoomStarted, room, client.ID)
+		}
+	}
+	res.duplicates = duplicateSessionsLocked(room, client)
+	room.LastActivity = time.Now()
+	if room.Host == "" && !client.Service {
+		room.Host = client.ID
+	}
+
+	client.admitted.Store(true)
+
+	participants := append(room.rosterLocked(client), remote...)
+	assignOfferers(client.ID, participants)
+
+	// Confirm the join, then tell the new client who is already here so it
+	// can send offers. Send never blocks, so this is safe under the lock.
+	joined := Message{
+		Type:   "joined",
+		RoomID: room.ID,
+		// Echoed so guests learn the name they were given
+		Username: client.Username,
+		Session: &Session{
+			ClientID:         client.ID,
+			SessionID:        client.SessionID,
+			IsHost:           room.Host == client.ID,
+			ParticipantCount: room.participantCountLocked(),
+			JoinedAt:         client.JoinedAt,
+			Reconnected:      rejoin,
+			Protocol:         client.Protocol,
+		},
+	}
+	if err := client.Send(joined); err != nil {
+		slog.Warn("error sending join acknowledgement", "roomId", room.ID, "clientId", client.ID, "err", err)
+	}
+	if welcome := room.welcomeLocked(); welcome != nil {
+		client.Send(*welcome)
+	}
+	recording, locked := room.Recording, room.Locked
+	state := Message{
+		Type:         "room-state",
+		RoomID:       room.ID,
+		Host:         room.Host,
+		Presenter:    room.Presenter,
+		ScreenShare:  room.screenShareLocked(),
+		RaisedHands:  room.raisedHandsLocked(),
+		Recording:    &recording,
+		Locked:       &locked,
+		Breakout:     client.Breakout,
+		Settings:     room.Settings.raw(),
+		Participants: participants,
+	}
+	if err := client.Send(state); err != nil {
+		slog.Warn("error sending room state", "roomId", room.ID, "clientId", client.ID, "err", err)
+	}
+
+	// Replay recent chat to the new client only
+	for _, msg := range room.chatHistory {
+		if !room.reachesLocked(msg, client) {
+			continue
+		}
+		if err := client.Send(msg); err != nil {
+			slog.Warn("error replaying chat history", "roomId", room.ID, "clientId", client.ID, "err", err)
+			break
+		}
+	}
+
+	res.event = "join"
+	if rejoin {
+		res.event = "reconnect"
+	}
+	res.username = client.Username
+	// Notify other clients about new peer; service clients join silently
+	if !client.Service {
+		room.logEventLocked(res.event, client.ID, client.Username)
+		room.broadcastLocked(Message{
+			Type:         res.event,
+			From:         client.ID,
+			RoomID:       room.ID,
+			Username:     client.Username,
+			Capabilities: client.Capabilities,
+		})
+	}
+	return res, ""
+}
+
+// finishJoin does what is left of a join admitLocked completed, the work
+// that mustn't happen under room.mu. It must be called without room.mu
+// held.
+func (h *Hub) finishJoin(room *Room, client *Client, res joinResult) {
+	if res.previous != nil {
+		// The stale connection's cleanup sees it has been replaced and
+		// won't announce a leave
+		slog.Info("replacing existing connection", "roomId", room.ID, "clientId", client.ID, "oldSessionId", res.previous.SessionID, "sessionId", client.SessionID)
+		res.previous.Close()
+	}
+	h.bus.registerClient(client)
+	// A client admitted from the waiting room has its own handleMessages
+	// running, which may already have removed it again. Its leave was
+	// announced after its join, under the same lock, but the cleanup may
+	// have unregistered it before the line above; undo that registration
+	// rather than leave a ghost for other instances.
+	room.mu.Lock()
+	member := room.Clients[client.ID] == client
+	room.mu.Unlock()
+	if !member {
+		h.bus.unregisterClient(client)
+		return
+	}
+	resolveDuplicateSessions(client, res.duplicates)
+	h.sendLinkedPresenters(room, client)
+
+	slog.Info("client joined", "event", res.event, "roomId", room.ID, "clientId", client.ID, "username", res.username)
+	roomEventsTotal.WithLabelValues(res.event).Inc()
+}
+
+// queueLocked parks client in the waiting room and asks the host to admit
+// it. The caller must hold r.mu.
+func (r *Room) queueLocked(client *Client) {
+	if stale, ok := r.Pending[client.ID]; ok {
+		if stale.UserID != client.UserID {
+			slog.Warn("rejected duplicate client id", "event", "duplicate-id", "roomId", r.ID, "clientId", client.ID, "userId", client.UserID, "existingUserId", stale.UserID)
+			client.SendAndClose(Message{Type: "duplicate-id", RoomID: r.ID, Text: "clientId is already in use"})
+			return
+		}
+		slog.Info("replacing waiting connection", "roomId", r.ID, "clientId", client.ID, "oldSessionId", stale.SessionID, "sessionId", client.SessionID)
+		stale.Close()
+	}
+	r.Pending[client.ID] = client
+	slog.Info("client waiting for admission", "event", "waiting", "roomId", r.ID, "clientId", client.ID)
+
+	client.Send(Message{Type: "waiting", RoomID: r.ID})
+	r.requestAdmissionLocked(client)
+}
+
+// requestAdmissionLocked asks the current host to admit client. The caller
+// must hold r.mu.
+func (r *Room) requestAdmissionLocked(client *Client) {
+	if host, ok := r.Clients[r.Host]; ok {
+		host.Send(Message{Type: "admission-request", From: client.ID, RoomID: r.ID, Username: client.Username})
+	}
+}
+
+// admit lets a waiting client into the room. Only the host may admit.
+func (h *Hub) admit(room *Room, from *Client, targetID string) {
+	remote := h.bus.remoteParticipants(room.ID)
+
+	room.mu.Lock()
+	if room.Host != from.ID {
+		room.mu.Unlock()
+		from.sendError("not-host", "only the host can admit participants")
+		return
+	}
+	client, ok := room.Pending[targetID]
+	if !ok {
+		room.mu.Unlock()
+		from.sendError("peer-not-found", "no client "+targetID+" is waiting")
+		return
+	}
+	delete(room.Pending, targetID)
+	res, rejected := h.admitLocked(room, client, remote)
+	room.mu.Unlock()
+
+	if rejected != "" {
+		client.SendAndClose(Message{Type: rejected, RoomID: room.ID})
+		return
+	}
+	h.finishJoin(room, client, res)
+}
+
+// deny turns a waiting client away. Only the host may deny.
+func (r *Room) deny(from *Client, targetID string) {
+	r.mu.Lock()
+	if r.Host != from.ID {
+		r.mu.Unlock()
+		from.sendError("not-host", "only the host can deny participants")
+		return
+	}
+	client, ok := r.Pending[targetID]
+	delete(r.Pending, targetID)
+	r.mu.Unlock()
+
+	if ok {
+		slog.Info("admission denied", "event", "admission-denied", "roomId", r.ID, "clientId", targetID)
+		client.SendAndClose(Message{Type: "admission-denied", RoomID: r.ID})
+	}
+}
+
+// removePendingLocked drops a waiting client that disconnected and lets the
+// host clear its request. It reports whether the client was waiting. The
+// caller must hold r.mu.
+func (r *Room) removePendingLocked(client *Client) bool {
+	if r.Pending[client.ID] != client {
+		return false
+	}
+	delete(r.Pending, client.ID)
+	if host, ok := r.Clients[r.Host]; ok {
+		host.Send(Message{Type: "admission-cancelled", From: client.ID, RoomID: r.ID})
+	}
+	return true
+}
+
+// handoffPendingLocked deals with the waiting room after the host leaves:
+// the new host inherits every pending request, or if nobody is left to
+// admit them, waiting clients are sent away. The caller must hold r.mu.
+func (r *Room) handoffPendingLocked() {
+	if len(r.Pending) == 0 {
+		return
+	}
+	if _, ok := r.Clients[r.Host]; ok {
+		for _, client := range r.Pending {
+			r.requestAdmissionLocked(client)
+		}
+		return
+	}
+	for id, client := range r.Pending {
+		delete(r.Pending, id)
+		client.SendAndClose(Message{Type: "admission-denied", RoomID: r.ID, Text: "The host left the meeting"})
+	}
+}
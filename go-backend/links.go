@@ -0,0 +1,323 @@
+package main
+
+import (
+	"log/slog"
+	"maps"
+	"slices"
+)
+
+// maxRoomLinks caps how many rooms one room may push its presenter into
+const maxRoomLinks = 8
+
+// Linked rooms let one room's presenter reach the participants of other
+// rooms. A host asks to link their room to a target and the target's host
+// accepts; the link is directed, from the presenting room to the linked
+// one, and is recorded on both sides (Links on the source, LinkedFrom on
+// the target). A link only counts while both records agree, so a room
+// that ended and was recreated under the same ID doesn't inherit stale
+// links. Rooms that keep strangers out, by a password, a lock or a waiting
+// room, can't be linked into at all: a link would let the presenter reach
+// their members without passing any of those.
+//
+// Relaying is a single hop: signaling crosses exactly one link and is
+// then delivered straight to the target client, never routed again, so a
+// cycle of links can't make a message loop. Viewers in a linked room
+// learn the presenter from presenter-changed and negotiate with it as
+// with any other peer; messages that crossed a link carry the room on the
+// other side in linkedRoom.
+
+// protectedLocked reports whether r admits only the people it lets in
+// itself, which rules out linking into it. The caller must hold r.mu.
+func (r *Room) protectedLocked() bool {
+	return r.Locked || r.PasswordHash != nil || r.Settings.WaitingRoom
+}
+
+// linkRoom asks the host of targetID to let room's presenter into it, on
+// behalf of room's host. Nothing is linked until they accept-link.
+func (h *Hub) linkRoom(room *Room, from *Client, targetID string) {
+	if targetID == room.ID {
+		from.sendError("invalid-link", "a room can't be linked to itself")
+		return
+	}
+	target, exists := h.GetRoom(targetID)
+	if !exists {
+		from.sendError("room-not-found", "no room "+targetID)
+		return
+	}
+
+	room.mu.Lock()
+	if room.Host != from.ID {
+		room.mu.Unlock()
+		from.sendError("not-host", "only the host can link rooms")
+		return
+	}
+	if room.Links[targetID] {
+		room.mu.Unlock()
+		return
+	}
+	if len(room.Links) >= maxRoomLinks {
+		room.mu.Unlock()
+		from.sendError("too-many-links", "this room is already linked to the maximum number of rooms")
+		return
+	}
+	room.mu.Unlock()
+
+	target.mu.Lock()
+	if target.protectedLocked() {
+		target.mu.Unlock()
+		from.sendError("link-refused", "room "+targetID+" doesn't accept links")
+		return
+	}
+	host, ok := target.Clients[target.Host]
+	if !ok {
+		target.mu.Unlock()
+		from.sendError("link-refused", "room "+targetID+" has no host to accept the link")
+		return
+	}
+	target.linkRequests[room.ID] = room
+	host.Send(Message{Type: "link-request", From: from.ID, RoomID: target.ID, LinkedRoom: room.ID})
+	target.mu.Unlock()
+
+	from.Send(Message{Type: "link-requested", RoomID: room.ID, LinkedRoom: targetID})
+	slog.Info("room link requested", "event", "link-requested", "roomId", room.ID, "linkedRoom", targetID, "clientId", from.ID)
+}
+
+// acceptLink completes the link sourceID asked for into room, on behalf of
+// room's host, and tells both rooms
+func (h *Hub) acceptLink(room *Room, from *Client, sourceID string) {
+	room.mu.Lock()
+	if room.Host != from.ID {
+		room.mu.Unlock()
+		from.sendError("not-host", "only the host can accept links")
+		return
+	}
+	source, requested := room.linkRequests[sourceID]
+	delete(room.linkRequests, sourceID)
+	if !requested {
+		room.mu.Unlock()
+		from.sendError("not-requested", "room "+sourceID+" hasn't asked to link here")
+		return
+	}
+	if room.protectedLocked() {
+		room.mu.Unlock()
+		from.sendError("link-refused", "a locked room, or one with a password or waiting room, can't be linked into")
+		return
+	}
+	room.mu.Unlock()
+
+	// The request binds the room that made it, not a later one reusing
+	// its ID
+	if current, exists := h.GetRoom(sourceID); !exists || current != source {
+		from.sendError("room-not-found", "no room "+sourceID)
+		return
+	}
+
+	source.mu.Lock()
+	if source.closed {
+		source.mu.Unlock()
+		from.sendError("room-not-found", "no room "+sourceID)
+		return
+	}
+	if !source.Links[room.ID] && len(source.Links) >= maxRoomLinks {
+		source.mu.Unlock()
+		from.sendError("too-many-links", "room "+sourceID+" is already linked to the maximum number of rooms")
+		return
+	}
+	source.Links[room.ID] = true
+	presenter := source.Presenter
+	source.broadcastLocked(Message{Type: "room-linked", From: from.ID, RoomID: source.ID, LinkedRoom: room.ID})
+	source.mu.Unlock()
+
+	room.mu.Lock()
+	room.LinkedFrom[sourceID] = true
+	msg := Message{Type: "room-linked", From: from.ID, RoomID: room.ID, LinkedRoom: sourceID, Presenter: presenter}
+	room.broadcastLocked(msg)
+	from.Send(msg)
+	room.mu.Unlock()
+
+	slog.Info("room linked", "event", "room-linked", "roomId", sourceID, "linkedRoom", room.ID, "clientId", from.ID)
+}
+
+// unlinkRoom removes the link between room and otherID in whichever
+// direction it runs, so the host of either end can cut it. On a link
+// otherID only asked for, it declines the request.
+func (h *Hub) unlinkRoom(room *Room, from *Client, otherID string) {
+	room.mu.Lock()
+	if room.Host != from.ID {
+		room.mu.Unlock()
+		from.sendError("not-host", "only the host can unlink rooms")
+		return
+	}
+	outgoing, incoming := room.Links[otherID], room.LinkedFrom[otherID]
+	if source, requested := room.linkRequests[otherID]; requested {
+		// Declining a request that was never accepted
+		delete(room.linkRequests, otherID)
+		room.mu.Unlock()
+		from.Send(Message{Type: "room-unlinked", From: from.ID, RoomID: room.ID, LinkedRoom: otherID})
+		source.mu.Lock()
+		if host, ok := source.Clients[source.Host]; ok {
+			host.Send(Message{Type: "link-declined", From: from.ID, RoomID: source.ID, LinkedRoom: room.ID})
+		}
+		source.mu.Unlock()
+		return
+	}
+	if !outgoing && !incoming {
+		room.mu.Unlock()
+		from.sendError("not-linked", "this room is not linked to "+otherID)
+		return
+	}
+	delete(room.Links, otherID)
+	delete(room.LinkedFrom, otherID)
+	msg := Message{Type: "room-unlinked", From: from.ID, RoomID: room.ID, LinkedRoom: otherID}
+	room.broadcastLocked(msg)
+	from.Send(msg)
+	room.mu.Unlock()
+
+	if other, exists := h.GetRoom(otherID); exists {
+		other.mu.Lock()
+		if outgoing {
+			delete(other.LinkedFrom, room.ID)
+		}
+		if incoming {
+			delete(other.Links, room.ID)
+		}
+		other.broadcastLocked(Message{Type: "room-unlinked", From: from.ID, RoomID: other.ID, LinkedRoom: room.ID})
+		other.mu.Unlock()
+	}
+
+	slog.Info("room unlinked", "event", "room-unlinked", "roomId", room.ID, "linkedRoom", otherID, "clientId", from.ID)
+}
+
+// setPresenter designates the participant whose signaling is relayed into
+// linked rooms; an empty targetID clears it. Only the host may choose.
+func (h *Hub) setPresenter(room *Room, from *Client, targetID string) {
+	room.mu.Lock()
+	if room.Host != from.ID {
+		room.mu.Unlock()
+		from.sendError("not-host", "only the host can choose the presenter")
+		return
+	}
+	if targetID != "" {
+		if _, ok := room.Clients[targetID]; !ok {
+			room.mu.Unlock()
+			from.sendError("peer-not-found", "no client "+targetID+" in this room")
+			return
+		}
+	}
+	if room.Presenter == targetID {
+		room.mu.Unlock()
+		return
+	}
+	room.Presenter = targetID
+	msg := Message{Type: "presenter-changed", From: from.ID, RoomID: room.ID, Presenter: targetID}
+	room.broadcastLocked(msg)
+	from.Send(msg)
+	links := slices.Collect(maps.Keys(room.Links))
+	room.mu.Unlock()
+
+	slog.Info("presenter changed", "event", "presenter-changed", "roomId", room.ID, "clientId", targetID, "by", from.ID)
+	h.announcePresenter(room.ID, links, targetID)
+}
+
+// presenterLeft clears room's presenter if that was client, telling the
+// room and every room it is linked to
+func (h *Hub) presenterLeft(room *Room, client *Client) {
+	room.mu.Lock()
+	if room.Presenter != client.ID {
+		room.mu.Unlock()
+		return
+	}
+	room.Presenter = ""
+	room.broadcastLocked(Message{Type: "presenter-changed", RoomID: room.ID})
+	links := slices.Collect(maps.Keys(room.Links))
+	room.mu.Unlock()
+
+	h.announcePresenter(room.ID, links, "")
+}
+
+// announcePresenter tells the rooms sourceID links to who presents there
+// now. It takes one room's lock at a time.
+func (h *Hub) announcePresenter(sourceID string, links []string, presenter string) {
+	for _, id := range links {
+		target, exists := h.GetRoom(id)
+		if !exists {
+			continue
+		}
+		target.mu.Lock()
+		if target.LinkedFrom[sourceID] {
+			target.broadcastLocked(Message{Type: "presenter-changed", RoomID: target.ID, LinkedRoom: sourceID, Presenter: presenter})
+		}
+		target.mu.Unlock()
+	}
+}
+
+// sendLinkedPresenters tells a joiner of room about the presenters of the
+// rooms linked into it, so it can connect to them like the viewers who
+// were already there. It must be called without room.mu held.
+func (h *Hub) sendLinkedPresenters(room *Room, client *Client) {
+	room.mu.Lock()
+	sources := slices.Collect(maps.Keys(room.LinkedFrom))
+	room.mu.Unlock()
+
+	for _, id := range sources {
+		source, exists := h.GetRoom(id)
+		if !exists {
+			continue
+		}
+		source.mu.Lock()
+		presenter := ""
+		if source.Links[room.ID] {
+			presenter = source.Presenter
+		}
+		source.mu.Unlock()
+		if presenter != "" {
+			client.Send(Message{Type: "presenter-changed", RoomID: room.ID, LinkedRoom: id, Presenter: presenter})
+		}
+	}
+}
+
+// forwardLinked delivers a negotiation message whose target isn't in the
+// sender's room across a link: from a presenter to a viewer in a room its
+// room links to, or from such a viewer back to the presenter. It reports
+// whether the message was delivered.
+func (h *Hub) forwardLinked(room *Room, msg Message) bool {
+	room.mu.Lock()
+	var candidates []string
+	if msg.From == room.Presenter {
+		candidates = slices.Collect(maps.Keys(room.Links))
+	} else {
+		candidates = slices.Collect(maps.Keys(room.LinkedFrom))
+	}
+	presenting := msg.From == room.Presenter
+	room.mu.Unlock()
+
+	for _, id := range candidates {
+		other, exists := h.GetRoom(id)
+		if !exists {
+			continue
+		}
+		other.mu.Lock()
+		var target *Client
+		if presenting && other.LinkedFrom[room.ID] {
+			target = other.Clients[msg.To]
+		} else if !presenting && other.Links[room.ID] && other.Presenter == msg.To {
+			target = other.Clients[msg.To]
+		}
+		other.mu.Unlock()
+		if target == nil {
+			continue
+		}
+
+		// Delivered as-is, never routed again, which keeps relaying to a
+		// single hop
+		msg.LinkedRoom = room.ID
+		msg.RoomID = other.ID
+		if err := target.Send(msg); err != nil {
+			slog.Warn("error forwarding linked message", "roomId", room.ID, "linkedRoom", id, "clientId", msg.To, "from", msg.From, "msgType", msg.Type, "correlationId", msg.CorrelationID, "err", err)
+			return false
+		}
+		slog.Debug("message forwarded across link", "roomId", room.ID, "linkedRoom", id, "clientId", msg.To, "from", msg.From, "msgType", msg.Type, "correlationId", msg.CorrelationID)
+		return true
+	}
+	return false
+}
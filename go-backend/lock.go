@@ -0,0 +1,27 @@
+package main
+
+import "log/slog"
+
+// setLocked locks or unlocks the room. While locked, new participants are
+// turned away with room-locked; members reconnecting, clients the host
+// admits from the waiting room and service clients still get in. Only the
+// host may change it, and everyone is told the new state.
+func (r *Room) setLocked(client *Client, locked bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Host != client.ID {
+		client.sendError("not-host", "only the host can lock the room")
+		return
+	}
+	if r.Locked == locked {
+		return
+	}
+
+	r.Locked = locked
+	slog.Info("room lock changed", "event", "lock", "roomId", r.ID, "clientId", client.ID, "locked", locked)
+	msg := Message{Type: "lock-state", From: client.ID, RoomID: r.ID, Locked: &locked}
+	r.broadcastLocked(msg)
+	// Echo to the host as confirmation
+	client.Send(msg)
+}
@@ -1,29 +1,30 @@
 package main
 
 import (
+	"compress/flate"
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
+	"golang.org/x/crypto/bcrypt"
 )
 
-// Room stores information about connected clients
-type Room struct {
-	Clients map[string]*Client
-	mu      sync.Mutex
-}
-
-// Client represents a connected websocket client
-type Client struct {
-	Conn     *websocket.Conn
-	ID       string
-	RoomID   string
-	Username string
-}
-
 // Message represents a message exchanged between clients
 type Message struct {
 	Type      string          `json:"type"`
@@ -33,245 +34,1225 @@ type Message struct {
 	Username  string          `json:"username,omitempty"`
 	SDP       json.RawMessage `json:"sdp,omitempty"`
 	Candidate json.RawMessage `json:"candidate,omitempty"`
+	Text      string          `json:"message,omitempty"`
+	Code      string          `json:"code,omitempty"`
+	Host      string          `json:"host,omitempty"`
+
+	// ToMany addresses a multicast message to several peers instead of To
+	ToMany []string `json:"toMany,omitempty"`
+	// Reason says why an ice-restart or renegotiate was requested, e.g.
+	// "network-change" or "ice-failed"
+	Reason string `json:"reason,omitempty"`
+	// Payload is opaque client-defined data carried by relay messages
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// MsgID is an optional sender-chosen ID on chat messages. When set the
+	// server acknowledges the message with a chat-ack carrying it, see
+	// Client.ackChat, and recipients get it too so they can drop resends.
+	MsgID string `json:"msgId,omitempty"`
+	// CorrelationID follows a message through logs and onto the peers it
+	// is relayed to. Clients may supply one (e.g. reusing an offer's ID on
+	// the answer); otherwise the server assigns one.
+	CorrelationID string `json:"correlationId,omitempty"`
+	// Timestamp is when the server processed the message. It is always
+	// assigned server-side; anything a client sends is overwritten.
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+
+	// Screen share state: Sharing toggles it on screen-share messages and
+	// ScreenShare describes the active share in room-state
+	Sharing     *bool        `json:"sharing,omitempty"`
+	StreamID    string       `json:"streamId,omitempty"`
+	ScreenShare *ScreenShare `json:"screenShare,omitempty"`
+
+	// Raise hand state: Raised toggles it on raise-hand messages and
+	// RaisedHands lists raised hands, earliest first, in room-state
+	Raised      *bool    `json:"raised,omitempty"`
+	RaisedHands []string `json:"raisedHands,omitempty"`
+
+	// Recording is whether the room is being recorded, in recording-state
+	// and room-state
+	Recording *bool `json:"recording,omitempty"`
+
+	// Locked is whether new participants are kept out, in lock-state and
+	// room-state
+	Locked *bool `json:"locked,omitempty"`
+
+	// LinkedRoom is the other room of link-room, accept-link and
+	// unlink-room, and on signaling relayed across a link the room it came
+	// from. Presenter is whose signaling linked rooms receive, see links.go.
+	LinkedRoom string `json:"linkedRoom,omitempty"`
+	Presenter  string `json:"presenter,omitempty"`
+
+	// Media state; pointers so a client can toggle one without the other
+	AudioEnabled *bool `json:"audioEnabled,omitempty"`
+	VideoEnabled *bool `json:"videoEnabled,omitempty"`
+	IsTyping     *bool `json:"isTyping,omitempty"`
+
+	// File share metadata; the file itself lives in external storage
+	FileName string `json:"fileName,omitempty"`
+	FileSize int64  `json:"fileSize,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+	URL      string `json:"url,omitempty"`
+
+	// Connection quality: Stats carries a client's stats-report and
+	// Quality the level derived from it that is relayed to the room
+	Stats   *ConnectionStats `json:"stats,omitempty"`
+	Quality string           `json:"quality,omitempty"`
+	// Layer is the simulcast layer a set-quality message asks its target
+	// to send: low, medium or high. TargetClientID names that target as
+	// the set-quality schema specifies; it is an alias of To.
+	Layer          string `json:"layer,omitempty"`
+	TargetClientID string `json:"targetClientId,omitempty"`
+
+	// Breakout names a breakout room: the target of create-breakout and
+	// close-breakout, the sub-channel a scoped broadcast belongs to, and in
+	// room-state the joiner's own breakout. ClientIDs lists who moved.
+	Breakout  string   `json:"breakout,omitempty"`
+	ClientIDs []string `json:"clientIds,omitempty"`
+
+	Participants []Participant `json:"participants,omitempty"`
+
+	// Settings carries the room's RoomSettings in room-state and
+	// settings-changed, and a partial update in update-settings
+	Settings json.RawMessage `json:"settings,omitempty"`
+
+	// Session confirms a successful join in the joined message
+	Session *Session `json:"session,omitempty"`
+	// Room answers get-room-info
+	Room *RoomDetails `json:"room,omitempty"`
+	// Features answers get-features
+	Features *ServerFeatures `json:"features,omitempty"`
+
+	// Capabilities are the joiner's advertised capabilities in join and
+	// reconnect events
+	Capabilities json.RawMessage `json:"capabilities,omitempty"`
+
+	// closeAfter tells writePump to close the connection once this message
+	// has been written. It is never serialized.
+	closeAfter bool
 }
 
+// Session is what a client learns about its own connection once it has
+// joined a room
+type Session struct {
+	ClientID string `json:"clientId"`
+	// SessionID identifies this connection; it changes on every reconnect
+	// while ClientID stays the same
+	SessionID        string    `json:"sessionId"`
+	IsHost           bool      `json:"isHost"`
+	ParticipantCount int       `json:"participantCount"`
+	JoinedAt         time.Time `json:"joinedAt"`
+	Reconnected      bool      `json:"reconnected"`
+	// Protocol is the negotiated signaling protocol version
+	Protocol string `json:"protocol"`
+}
+
+// Participant describes another member of a room in a room-state snapshot
+type Participant struct {
+	ClientID     string `json:"clientId"`
+	Username     string `json:"username"`
+	AudioEnabled bool   `json:"audioEnabled"`
+	VideoEnabled bool   `json:"videoEnabled"`
+	Quality      string `json:"quality,omitempty"`
+	Breakout     string `json:"breakout,omitempty"`
+	// Offerer tells the recipient of a room-state whether it should send
+	// the offer to this participant, see shouldOffer
+	Offerer *bool `json:"offerer,omitempty"`
+	// Capabilities are what the participant advertised when it joined
+	Capabilities json.RawMessage `json:"capabilities,omitempty"`
+}
+
+const (
+	// pongWait is how long we wait for a pong before treating the peer as dead
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so a live peer always answers in time
+	pingPeriod = (pongWait * 9) / 10
+	// writeWait bounds how long any single frame may take to write; a peer
+	// that can't accept one in time is treated as dead
+	writeWait = 10 * time.Second
+
+	// shutdownGrace is how long clients get to drain and close after being
+	// told the server is going away
+	shutdownGrace = 2 * time.Second
+	// shutdownTimeout bounds the whole graceful shutdown sequence
+	shutdownTimeout = 10 * time.Second
+)
+
+// handshakeTimeout bounds everything before a websocket is established:
+// reading the request headers, our own checks, and writing the upgrade
+// response. A client that stalls in between is cut off rather than holding
+// a connection and goroutine open.
+var handshakeTimeout = time.Duration(envInt("HANDSHAKE_TIMEOUT_SECONDS", 10)) * time.Second
+
+// maxRoomClients caps the size of a room; a full WebRTC mesh gets
+// expensive quickly so keep this small
+var maxRoomClients = envInt("MAX_ROOM_CLIENTS", 8)
+
+// maxTotalRooms and maxTotalClients cap the whole server so a single
+// abusive actor can't exhaust memory by opening rooms or connections
 var (
-	rooms = make(map[string]*Room)
-	mu    sync.Mutex
+	maxTotalRooms   = envInt("MAX_TOTAL_ROOMS", 1000)
+	maxTotalClients = envInt("MAX_TOTAL_CLIENTS", 5000)
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow connections from any origin
-	},
+// assignClientIDs makes the server pick every participant's clientId,
+// returned in the joined message, instead of trusting the one in the query
+// string, so nobody can take over another peer's ID. Reconnects then join
+// as new participants. Service clients, being trusted, keep their own.
+var assignClientIDs = envBool("ASSIGN_CLIENT_IDS", false)
+
+// strictRooms stops websocket joins from creating rooms, so rooms only
+// come into being through POST /api/rooms
+var strictRooms = envBool("STRICT_ROOMS", false)
+
+// sendBufferSize is how many outbound messages may queue per client before
+// it is considered a slow consumer and dropped
+var sendBufferSize = envInt("SEND_BUFFER_SIZE", 256)
+
+// chatHistorySize is how many recent chat messages each room keeps for
+// replay to late joiners
+var chatHistorySize = envInt("CHAT_HISTORY_SIZE", 50)
+
+// envInt reads a positive integer from the environment, falling back to
+// def when the variable is unset or invalid
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		slog.Warn("invalid config value, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return n
 }
 
-func main() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", handleWebSocket)
-	mux.HandleFunc("/api/rooms", handleRooms)
-
-	// Apply CORS middleware
-	handler := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
-		AllowCredentials: true,
-	}).Handler(mux)
-
-	log.Println("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", handler))
+// envString reads a string from the environment, falling back to def
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envBool reads a boolean from the environment, falling back to def when
+// the variable is unset or invalid
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("invalid config value, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return b
+}
+
+// envList reads a comma-separated list from the environment, dropping
+// empty entries
+func envList(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// allowedOrigins lists the origins permitted to open websockets and make
+// CORS requests. "*" allows any origin, which is the default for local dev.
+var allowedOrigins = envList("ALLOWED_ORIGINS", []string{"*"})
+
+// corsAllowCredentials lets browsers send cookies and auth headers on
+// cross-origin API requests. It only takes effect with an explicit
+// ALLOWED_ORIGINS list, see corsCredentials.
+var corsAllowCredentials = envBool("CORS_ALLOW_CREDENTIALS", true)
+
+// corsCredentials reports whether CORS responses allow credentials. Never
+// with a "*" origin: that would let any site make credentialed requests
+// as its visitors.
+func corsCredentials() bool {
+	return corsAllowCredentials && !slices.Contains(allowedOrigins, "*")
 }
 
-func handleRooms(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" {
-		// Create a new room
-		roomID := generateRoomID()
-		mu.Lock()
-		rooms[roomID] = &Room{
-			Clients: make(map[string]*Client),
+// originAllowed reports whether origin matches the allowlist
+func originAllowed(origin string) bool {
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
 		}
-		mu.Unlock()
+	}
+	return false
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"roomId": roomID})
-		return
+var (
+	readBufferSize  = envInt("WS_READ_BUFFER_SIZE", 4096)
+	writeBufferSize = envInt("WS_WRITE_BUFFER_SIZE", 4096)
+	// maxMessageSize caps a single inbound message; large SDP offers are a
+	// few tens of KB so this leaves plenty of headroom
+	maxMessageSize = int64(envInt("WS_MAX_MESSAGE_BYTES", 512<<10))
+	// compressionEnabled offers permessage-deflate to clients. SDP and ICE
+	// payloads compress well but deflate costs CPU, so it is opt-in.
+	compressionEnabled = envBool("WS_COMPRESSION", false)
+	compressionLevel   = envCompressionLevel("WS_COMPRESSION_LEVEL", flate.BestSpeed)
+)
+
+// envCompressionLevel reads a flate compression level from the environment,
+// falling back to def when it is unset or outside the range flate accepts
+func envCompressionLevel(key string, def int) int {
+	level := envInt(key, def)
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		slog.Warn("invalid compression level, using default", "key", key, "value", level, "default", def)
+		return def
 	}
+	return level
+}
 
-	if r.Method == "GET" {
-		// List active rooms
-		mu.Lock()
-		roomIDs := make([]string, 0, len(rooms))
-		for id := range rooms {
-			roomIDs = append(roomIDs, id)
+// supportedProtocols are the signaling protocol versions this server
+// speaks, preferred first. Clients that don't ask for one get
+// defaultProtocol.
+var supportedProtocols = []string{"vc-signal-v1", "vc-signal-v1+msgpack"}
+
+const defaultProtocol = "vc-signal-v1"
+
+// negotiableProtocol reports whether r asks for no subprotocol or for at
+// least one we support
+func negotiableProtocol(r *http.Request) bool {
+	requested := websocket.Subprotocols(r)
+	if len(requested) == 0 {
+		return true
+	}
+	for _, p := range requested {
+		if slices.Contains(supportedProtocols, p) {
+			return true
+		}
+	}
+	return false
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  readBufferSize,
+	WriteBufferSize: writeBufferSize,
+	// Deadline for writing the handshake response
+	HandshakeTimeout: handshakeTimeout,
+	// The selected version is echoed back in Sec-WebSocket-Protocol
+	Subprotocols: supportedProtocols,
+	// Clients that don't offer permessage-deflate just get uncompressed
+	// frames; the extension is only used when both sides agree to it
+	EnableCompression: compressionEnabled,
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		writeJSONError(w, status, reason.Error())
+	},
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		// Non-browser clients don't send an Origin header
+		if origin == "" {
+			return true
+		}
+		if !originAllowed(origin) {
+			slog.Warn("rejected websocket origin", "origin", origin)
+			return false
 		}
-		mu.Unlock()
+		return true
+	},
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string][]string{"rooms": roomIDs})
-		return
+// setupLogging installs a JSON slog handler at the level named by LOG_LEVEL
+// (debug, info, warn or error; info by default)
+func setupLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(envString("LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
 	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+}
+
+// newRouter returns the server's HTTP handler for hub: every route behind
+// the CORS middleware. It is all a test needs to serve a hub, e.g. with
+// signaltest.NewServer.
+func newRouter(hub *Hub) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", handleWebSocket(hub))
+	mux.HandleFunc("/api/rooms", handleRooms(hub))
+	mux.HandleFunc("/api/rooms/{roomId}", handleRoom(hub))
+	mux.HandleFunc("DELETE /api/rooms/{roomId}", handleDeleteRoom(hub))
+	mux.HandleFunc("GET /api/rooms/{roomId}/events", handleRoomEvents(hub))
+	mux.HandleFunc("GET /api/rooms/{roomId}/transcript", handleRoomTranscript(hub))
+	mux.HandleFunc("POST /api/rooms/{roomId}/message", handlePostMessage(hub))
+	mux.HandleFunc("POST /api/rooms/{roomId}/clients/{clientId}/disconnect", handleDisconnectClient(hub))
+	mux.HandleFunc("POST /api/announce", handleAnnounce(hub))
+	mux.HandleFunc("/api/drain", handleDrain(hub))
+	mux.HandleFunc("POST /api/admin/gc", handleGC(hub))
+	mux.HandleFunc("GET /api/stats", handleStats(hub))
+	mux.HandleFunc("/api/ice-servers", handleICEServers(hub))
+	mux.HandleFunc("GET /api/features", handleFeatures(hub))
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", handleHealthz(hub))
+	mux.HandleFunc("GET /readyz", handleReadyz)
 
-	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Apply CORS middleware. Credentials are only allowed for explicitly
+	// listed origins, which the cors package echoes back one at a time; it
+	// always adds Vary: Origin, so caches keep per-origin responses apart.
+	corsOptions := cors.Options{
+		AllowedOrigins:   allowedOrigins,
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-Admin-Key"},
+		AllowCredentials: corsCredentials(),
+	}
+	return cors.New(corsOptions).Handler(mux)
 }
 
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	roomID := r.URL.Query().Get("roomId")
-	clientID := r.URL.Query().Get("clientId")
-	username := r.URL.Query().Get("username")
+func main() {
+	setupLogging()
 
-	if roomID == "" || clientID == "" || username == "" {
-		http.Error(w, "Missing required parameters", http.StatusBadRequest)
-		return
+	if !authDisabled && len(jwtSecret) == 0 {
+		slog.Error("JWT_SECRET must be set (or AUTH_DISABLED=true for local development)")
+		os.Exit(1)
+	}
+	if corsAllowCredentials && !corsCredentials() {
+		slog.Warn("CORS credentials disabled because ALLOWED_ORIGINS allows any origin; list the origins explicitly to allow them")
 	}
 
-	mu.Lock()
-	room, exists := rooms[roomID]
-	if !exists {
-		rooms[roomID] = &Room{
-			Clients: make(map[string]*Client),
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hub := NewHub()
+	if redisURL := envString("REDIS_URL", ""); redisURL != "" {
+		bus, err := newRedisBus(ctx, redisURL, hub)
+		if err != nil {
+			slog.Error("error connecting to redis", "err", err)
+			os.Exit(1)
 		}
-		room = rooms[roomID]
+		hub.bus = bus
+		slog.Info("sharing rooms via redis", "instance", bus.instanceID)
 	}
-	mu.Unlock()
-
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println("Error upgrading to WebSocket:", err)
-		return
+	if transcriptDir != "" {
+		transcripts, err := newTranscriptWriter(transcriptDir)
+		if err != nil {
+			slog.Error("error opening transcript directory", "dir", transcriptDir, "err", err)
+			os.Exit(1)
+		}
+		hub.transcripts = transcripts
+		slog.Info("writing chat transcripts", "dir", transcriptDir)
 	}
+	if webhookURL != "" {
+		hub.webhooks = newWebhookSender(webhookURL, webhookSecret)
+		slog.Info("sending room event webhooks", "url", webhookURL, "signed", webhookSecret != "")
+	}
+	go hub.runJanitor(ctx)
+	if rosterSyncInterval > 0 {
+		go hub.runRosterSync(ctx)
+	}
+
+	handler := newRouter(hub)
 
-	client := &Client{
-		Conn:     conn,
-		ID:       clientID,
-		RoomID:   roomID,
-		Username: username,
+	srv := &http.Server{
+		Addr:    envString("ADDR", ":8080"),
+		Handler: handler,
+		// Slow-loris clients trickling headers are dropped before any
+		// handler runs; websockets reset this deadline once upgraded
+		ReadHeaderTimeout: handshakeTimeout,
 	}
+	certFile, keyFile := envString("TLS_CERT_FILE", ""), envString("TLS_KEY_FILE", "")
+	useTLS := certFile != "" && keyFile != ""
+	if !useTLS && (certFile != "" || keyFile != "") {
+		slog.Warn("TLS_CERT_FILE and TLS_KEY_FILE must both be set to enable TLS")
+	}
+
+	go func() {
+		var err error
+		if useTLS {
+			slog.Info("server starting", "addr", srv.Addr, "mode", "wss")
+			err = srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			slog.Info("server starting", "addr", srv.Addr, "mode", "ws")
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server failed", "err", err)
+			os.Exit(1)
+		}
+	}()
 
-	// Add client to room
-	room.mu.Lock()
-	room.Clients[clientID] = client
-	room.mu.Unlock()
+	<-ctx.Done()
+	slog.Info("shutting down server")
+	shuttingDown.Store(true)
 
-	// Notify other clients about new peer
-	notifyRoom(roomID, clientID, "join", username)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	// Listen for messages from this client
-	go handleMessages(client, room)
+	// Stop accepting new connections first. Hijacked websocket connections
+	// are not tracked by http.Server, so close those ourselves.
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("error shutting down HTTP server", "err", err)
+	}
+	closeAllClients(shutdownCtx, hub)
+	if hub.transcripts != nil {
+		hub.transcripts.stop()
+	}
+	if hub.webhooks != nil {
+		hub.webhooks.stop(shutdownCtx)
+	}
+	slog.Info("server stopped")
 }
 
-func handleMessages(client *Client, room *Room) {
-	defer func() {
-		client.Conn.Close()
+// closeAllClients tells every connected client the server is going away,
+// sends a close frame, and forcibly closes whatever is still open once the
+// grace period (or ctx) runs out.
+func closeAllClients(ctx context.Context, hub *Hub) {
+	var clients []*Client
+	for _, room := range hub.ListRooms() {
 		room.mu.Lock()
-		delete(room.Clients, client.ID)
+		for _, client := range room.Clients {
+			clients = append(clients, client)
+		}
+		for _, client := range room.Pending {
+			clients = append(clients, client)
+		}
 		room.mu.Unlock()
+	}
 
-		// If room is empty, remove it
-		if len(room.Clients) == 0 {
-			mu.Lock()
-			delete(rooms, client.RoomID)
-			mu.Unlock()
-		} else {
-			// Notify others that peer has left
-			notifyRoom(client.RoomID, client.ID, "leave", client.Username)
+	for _, client := range clients {
+		if err := client.Send(Message{Type: "server-shutdown", RoomID: client.RoomID}); err != nil {
+			slog.Warn("error sending shutdown notice", "roomId", client.RoomID, "clientId", client.ID, "err", err)
 		}
-	}()
+	}
 
-	for {
-		messageType, payload, err := client.Conn.ReadMessage()
-		if err != nil {
-			log.Println("Error reading message:", err)
-			break
-		}
+	// Let the writers flush the notice and any in-flight broadcasts
+	select {
+	case <-time.After(shutdownGrace):
+	case <-ctx.Done():
+	}
 
-		if messageType != websocket.TextMessage {
+	// Each close frame may take up to writeWait against a stalled peer, so
+	// write them in parallel and no later than ctx allows
+	deadline := time.Now().Add(hub.writeWait)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		if ctx.Err() != nil {
+			client.Close()
 			continue
 		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.closeBy(websocket.CloseGoingAway, "server-shutdown", deadline)
+		}()
+	}
+	wg.Wait()
+	// Clients that weren't in a room, e.g. still being admitted, go too
+	hub.shutdown()
+}
 
-		var msg Message
-		if err := json.Unmarshal(payload, &msg); err != nil {
-			log.Println("Error unmarshaling message:", err)
-			continue
+func handleRooms(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			var req struct {
+				Name     string          `json:"name"`
+				Password string          `json:"password"`
+				Settings json.RawMessage `json:"settings"`
+				// WaitingRoom predates settings and is kept for older clients
+				WaitingRoom    bool   `json:"waitingRoom"`
+				WelcomeMessage string `json:"welcomeMessage"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+				writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+			if req.Name != "" {
+				name, err := validateRoomID(req.Name)
+				if err != nil {
+					writeJSONError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				req.Name = name
+			}
+
+			welcome, err := validateWelcomeMessage(req.WelcomeMessage)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			room := newRoom()
+			room.WelcomeMessage = welcome
+			room.Settings.WaitingRoom = req.WaitingRoom
+			if len(req.Settings) > 0 {
+				settings, err := room.Settings.merge(req.Settings)
+				if err != nil {
+					writeJSONError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				room.Settings = settings
+			}
+			if req.Password != "" {
+				hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+				if err != nil {
+					slog.Error("error hashing room password", "err", err)
+					writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+					return
+				}
+				room.PasswordHash = hash
+			}
+
+			room.HostToken = randomString(32)
+			roomID, err := hub.CreateRoom(room, req.Name)
+			if errors.Is(err, errRoomExists) {
+				writeJSONError(w, http.StatusConflict, "Room already exists")
+				return
+			}
+			if errors.Is(err, errServerAtCapacity) {
+				writeJSONError(w, http.StatusServiceUnavailable, "Server at capacity")
+				return
+			}
+			if err != nil {
+				slog.Error("error creating room", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"roomId": roomID, "instanceId": room.InstanceID, "hostToken": room.HostToken})
+			return
 		}
 
-		msg.From = client.ID
-		msg.RoomID = client.RoomID
+		if r.Method == "GET" {
+			// List rooms. ?active=true skips empty rooms; ?detailed=true
+			// returns summaries instead of bare IDs, which existing
+			// consumers don't expect.
+			activeOnly := r.URL.Query().Get("active") == "true"
+			detailed := r.URL.Query().Get("detailed") == "true"
 
-		// Handle different message types
-		switch msg.Type {
-		case "offer", "answer", "ice-candidate":
-			// Forward message to specific peer
-			if msg.To != "" {
-				forwardMessage(msg)
+			rooms := hub.ListRooms()
+			summaries := make([]RoomSummary, 0, len(rooms))
+			for _, room := range rooms {
+				summary := room.summary()
+				if activeOnly && summary.ClientCount == 0 {
+					continue
+				}
+				summaries = append(summaries, summary)
 			}
-		case "chat":
-			// Broadcast chat message to everyone in the room
-			broadcastToRoom(client.RoomID, msg)
+
+			if detailed {
+				writeJSON(w, http.StatusOK, map[string][]RoomSummary{"rooms": summaries})
+				return
+			}
+			roomIDs := make([]string, 0, len(summaries))
+			for _, summary := range summaries {
+				roomIDs = append(roomIDs, summary.RoomID)
+			}
+			writeJSON(w, http.StatusOK, map[string][]string{"rooms": roomIDs})
+			return
 		}
+
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
-func notifyRoom(roomID, clientID, eventType, username string) {
-	msg := Message{
-		Type:     eventType,
-		From:     clientID,
-		RoomID:   roomID,
-		Username: username,
-	}
+// RoomSummary is one entry of the detailed GET /api/rooms list
+type RoomSummary struct {
+	RoomID      string    `json:"roomId"`
+	ClientCount int       `json:"clientCount"`
+	MaxClients  int       `json:"maxClients"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
 
-	broadcastToRoom(roomID, msg)
+// summary snapshots the room for listing
+func (r *Room) summary() RoomSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return RoomSummary{
+		RoomID:      r.ID,
+		ClientCount: r.participantCountLocked(),
+		MaxClients:  r.Settings.MaxClients,
+		CreatedAt:   r.CreatedAt,
+	}
 }
 
-func forwardMessage(msg Message) {
-	mu.Lock()
-	room, exists := rooms[msg.RoomID]
-	mu.Unlock()
+// RoomDetails is the response body for GET /api/rooms/{roomId} and the
+// room field of room-info
+type RoomDetails struct {
+	RoomID       string        `json:"roomId"`
+	CreatedAt    time.Time     `json:"createdAt"`
+	ClientCount  int           `json:"clientCount"`
+	PendingCount int           `json:"pendingCount"`
+	Host         string        `json:"host,omitempty"`
+	Locked       bool          `json:"locked"`
+	Settings     RoomSettings  `json:"settings"`
+	Participants []Participant `json:"participants"`
+}
 
-	if !exists {
-		return
+// details snapshots the room with its public roster
+func (r *Room) details() RoomDetails {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return RoomDetails{
+		RoomID:       r.ID,
+		CreatedAt:    r.CreatedAt,
+		ClientCount:  r.participantCountLocked(),
+		PendingCount: len(r.Pending),
+		Host:         r.Host,
+		Locked:       r.Locked,
+		Settings:     r.Settings,
+		Participants: r.rosterLocked(nil),
 	}
+}
 
-	room.mu.Lock()
-	targetClient, exists := room.Clients[msg.To]
-	room.mu.Unlock()
+// handleRoom describes a room on GET. HEAD only answers whether it exists,
+// so a client can check a link before joining; neither ever creates the
+// room, unlike joining it.
+func handleRoom(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
 
-	if !exists {
-		return
+		roomID := r.PathValue("roomId")
+		room, exists := hub.GetRoom(roomID)
+		if r.Method == "HEAD" {
+			status := http.StatusOK
+			if !exists {
+				status = http.StatusNotFound
+			}
+			w.WriteHeader(status)
+			return
+		}
+		if !exists {
+			writeJSONError(w, http.StatusNotFound, "Room not found")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, room.details())
 	}
+}
 
-	msgBytes, err := json.Marshal(msg)
-	if err != nil {
-		log.Println("Error marshaling message:", err)
-		return
+// handleDeleteRoom ends a meeting for everyone. The caller must present the
+// room's host token as a bearer token, or the admin API key.
+func handleDeleteRoom(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID := r.PathValue("roomId")
+		room, exists := hub.GetRoom(roomID)
+		if !exists {
+			writeJSONError(w, http.StatusNotFound, "Room not found")
+			return
+		}
+		if !isAdmin(r) && !secretEqual(bearerToken(r), room.HostToken) {
+			writeJSONError(w, http.StatusForbidden, "Not allowed to close this room")
+			return
+		}
+
+		if !hub.CloseRoom(roomID) {
+			writeJSONError(w, http.StatusNotFound, "Room not found")
+			return
+		}
+		slog.Info("room closed", "event", "room-closed", "roomId", roomID)
+		w.WriteHeader(http.StatusNoContent)
 	}
+}
 
-	if err := targetClient.Conn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
-		log.Println("Error sending message:", err)
+// handleDisconnectClient forcibly closes one connection, for ops tooling
+// dealing with a misbehaving client. Unlike a host kick it is out of band
+// and requires the admin API key.
+func handleDisconnectClient(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			writeJSONError(w, http.StatusForbidden, "Admin API key required")
+			return
+		}
+		room, exists := hub.GetRoom(r.PathValue("roomId"))
+		if !exists {
+			writeJSONError(w, http.StatusNotFound, "Room not found")
+			return
+		}
+		if !room.disconnect(r.PathValue("clientId")) {
+			writeJSONError(w, http.StatusNotFound, "Client not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-func broadcastToRoom(roomID string, msg Message) {
-	mu.Lock()
-	room, exists := rooms[roomID]
-	mu.Unlock()
+// handlePostMessage lets server-side integrations post a chat message into
+// a room without holding a websocket open. The message is broadcast and
+// kept in chat history like any other. It requires the host token or the
+// admin API key.
+func handlePostMessage(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID := r.PathValue("roomId")
+		room, exists := hub.GetRoom(roomID)
+		if !exists {
+			writeJSONError(w, http.StatusNotFound, "Room not found")
+			return
+		}
+		if !isAdmin(r) && !secretEqual(bearerToken(r), room.HostToken) {
+			writeJSONError(w, http.StatusForbidden, "Not allowed to post to this room")
+			return
+		}
 
-	if !exists {
-		return
+		var req struct {
+			Username string `json:"username"`
+			Text     string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		username, err := validateUsername(req.Username)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		text := strings.TrimSpace(req.Text)
+		if text == "" {
+			writeJSONError(w, http.StatusBadRequest, "text is required")
+			return
+		}
+
+		hub.broadcastChat(room, Message{Type: "chat", RoomID: roomID, Username: username, Text: text})
+		slog.Info("chat posted via api", "event", "api-message", "roomId", roomID, "username", username)
+		w.WriteHeader(http.StatusNoContent)
 	}
+}
 
-	msgBytes, err := json.Marshal(msg)
-	if err != nil {
-		log.Println("Error marshaling message:", err)
-		return
+// writeJSONError writes {"error": message} with the given status code so
+// clients can always parse error bodies as JSON
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeJSON writes v as a JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("error encoding response", "err", err)
 	}
+}
 
-	room.mu.Lock()
-	for _, client := range room.Clients {
-		// Don't send message back to sender
-		if client.ID == msg.From {
-			continue
+func handleWebSocket(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if draining.Load() {
+			writeJSONError(w, http.StatusServiceUnavailable, "Server is draining")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), handshakeTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		roomID := r.URL.Query().Get("roomId")
+		clientID := r.URL.Query().Get("clientId")
+		username := r.URL.Query().Get("username")
+
+		// Service peers (SFU, recording bots) authenticate with the admin
+		// key instead of a user token
+		service := r.URL.Query().Get("service") == "true"
+		if service && !isAdmin(r) {
+			writeJSONError(w, http.StatusForbidden, "Admin API key required for service clients")
+			return
+		}
+
+		// With auth enabled the identity comes from the token, never from
+		// the query string
+		var userID string
+		if !hub.authDisabled && !service {
+			claims, err := authenticate(r)
+			if err != nil {
+				slog.Warn("rejected websocket token", "roomId", roomID, "clientId", clientID, "err", err)
+				writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+			userID, username = claims.Subject, claims.Username
+		} else if !service {
+			// Without auth a client may still name a stable user so its
+			// sessions can be told apart from other users'
+			userID = r.URL.Query().Get("userId")
+		}
+
+		if roomID == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required parameters")
+			return
+		}
+		var err error
+		if roomID, err = validateRoomID(roomID); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if assignClientIDs && !service {
+			clientID = randomString(16)
+		}
+		if clientID, err = validateClientID(clientID); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if hub.authDisabled && userID != "" {
+			if userID, err = validateUserID(userID); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		// Without a username the client joins as a guest and is named by
+		// enterRoom, which can see who else is in the room
+		if strings.TrimSpace(username) == "" {
+			username = ""
+		} else if username, err = validateUsername(username); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		capabilities, err := validateCapabilities(r.URL.Query().Get("capabilities"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Cheap early check; admitLocked enforces the client cap exactly
+		if hub.numClients.Load() >= int64(maxTotalClients) {
+			writeJSONError(w, http.StatusServiceUnavailable, "Server at capacity")
+			return
+		}
+		room, err := hub.joinableRoom(roomID)
+		if errors.Is(err, errRoomNotFound) {
+			writeJSONError(w, http.StatusNotFound, "Room not found")
+			return
+		}
+		if errors.Is(err, errServerAtCapacity) {
+			writeJSONError(w, http.StatusServiceUnavailable, "Server at capacity")
+			return
+		}
+		if err != nil {
+			slog.Error("error creating room", "roomId", roomID, "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		if !room.checkPassword(r.URL.Query().Get("password")) {
+			writeJSONError(w, http.StatusUnauthorized, "Invalid room password")
+			return
+		}
+
+		if !negotiableProtocol(r) {
+			writeJSONError(w, http.StatusBadRequest, "Unsupported protocol version; supported: "+strings.Join(supportedProtocols, ", "))
+			return
+		}
+
+		// Room lookup and the password check can be slow; don't upgrade a
+		// client that has already run out of time
+		if err := ctx.Err(); err != nil {
+			slog.Warn("websocket handshake timed out", "event", "handshake-timeout", "roomId", roomID, "clientId", clientID, "timeout", handshakeTimeout)
+			writeJSONError(w, http.StatusServiceUnavailable, "Handshake timed out")
+			return
+		}
+
+		// Service peers and allowlisted addresses aren't limited
+		var countedIP netip.Addr
+		if ip := clientIP(r); ip.IsValid() && !service && !containsAddr(connectionLimitAllowlist, ip) {
+			if !hub.ipConns.acquire(ip) {
+				slog.Warn("rejected connection over per-ip limit", "event", "ip-limit", "roomId", roomID, "clientId", clientID, "ip", ip)
+				writeJSONError(w, http.StatusTooManyRequests, "Too many connections from this address")
+				return
+			}
+			countedIP = ip
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has already written an error response
+			slog.Warn("websocket handshake rejected", "event", "handshake-rejected", "roomId", roomID, "clientId", clientID, "err", err)
+			if countedIP.IsValid() {
+				hub.ipConns.release(countedIP)
+			}
+			return
+		}
+		hub.connOpened()
+		if compressionEnabled {
+			// Both are no-ops when the client didn't negotiate compression
+			conn.EnableWriteCompression(true)
+			conn.SetCompressionLevel(compressionLevel)
 		}
 
-		if err := client.Conn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
-			log.Println("Error broadcasting message:", err)
+		client := newClient(hub.ctx, conn, clientID, roomID, username)
+		client.writeWait = hub.writeWait
+		client.UserID = userID
+		client.Service = service
+		client.Capabilities = capabilities
+		client.countedIP = countedIP
+		client.Protocol = conn.Subprotocol()
+		if client.Protocol == "" {
+			client.Protocol = defaultProtocol
 		}
+		client.codec = codecFor(client.Protocol)
+		client.touch()
+
+		go client.writePump()
+
+		room = hub.enterRoom(room, client)
+		if room == nil {
+			hub.connClosed(client)
+			return
+		}
+
+		// Listen for messages from this client
+		go handleMessages(hub, client, room)
 	}
-	room.mu.Unlock()
 }
 
-// Helper function to generate a random room ID
-func generateRoomID() string {
-	// In a real app, you'd use a more sophisticated ID generator
-	return "room-" + randomString(8)
+// logReadError logs why a client's read loop ended. Clean closes and
+// connections we closed ourselves are routine and only logged at debug;
+// anything else is an unexpected drop worth a warning with its close code.
+func logReadError(client *Client, err error) {
+	var closeErr *websocket.CloseError
+	switch {
+	case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+		slog.Debug("client closed connection", "roomId", client.RoomID, "clientId", client.ID, "err", err)
+	case errors.Is(err, net.ErrClosed):
+		slog.Debug("connection closed by server", "roomId", client.RoomID, "clientId", client.ID)
+	case errors.As(err, &closeErr):
+		slog.Warn("client disconnected unexpectedly", "event", "unexpected-close", "roomId", client.RoomID, "clientId", client.ID, "code", closeErr.Code, "err", err)
+	default:
+		slog.Warn("error reading message", "roomId", client.RoomID, "clientId", client.ID, "err", err)
+	}
 }
 
-func randomString(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[i%len(charset)]
+func handleMessages(hub *Hub, client *Client, room *Room) {
+	go heartbeat(client)
+
+	defer func() {
+		client.Close()
+		client.stopTyping()
+		hub.connClosed(client)
+		removed, empty := hub.removeClient(room, client)
+		if !removed {
+			return
+		}
+		hub.bus.unregisterClient(client)
+		hub.presenterLeft(room, client)
+		slog.Info("client left", "event", "leave", "roomId", client.RoomID, "clientId", client.ID)
+		hub.addClients(-1)
+		roomEventsTotal.WithLabelValues("leave").Inc()
+
+		if empty {
+			slog.Info("room removed", "event", "room-removed", "roomId", client.RoomID)
+		}
+	}()
+
+	client.Conn.SetReadLimit(maxMessageSize)
+	client.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	client.Conn.SetPongHandler(func(string) error {
+		return client.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	limiters := newClientLimiters()
+	// malformed counts consecutive payloads that weren't valid JSON
+	malformed := 0
+
+	for {
+		messageType, payload, err := client.Conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				// gorilla has already sent a 1009 close frame
+				slog.Warn("client sent oversized message", "event", "message-too-big", "roomId", client.RoomID, "clientId", client.ID, "limit", maxMessageSize)
+				break
+			}
+			logReadError(client, err)
+			break
+		}
+
+		if messageType != client.codec.frameType() {
+			continue
+		}
+
+		// Decode before rate limiting so the message can be charged to its
+		// category; anything undecodable counts as signaling
+		var msg Message
+		decodeErr := client.codec.unmarshal(payload, &msg)
+		limiter := limiters.signaling
+		if decodeErr == nil {
+			limiter = limiters.forType(msg.Type)
+		}
+		if ok, firstDrop := limiter.allow(); !ok {
+			if limiter.exceeded() {
+				slog.Warn("disconnecting flooding client", "event", "rate-limited", "roomId", client.RoomID, "clientId", client.ID)
+				client.SendAndClose(Message{Type: "rate-limited", RoomID: client.RoomID, Text: "Disconnected for sending too many messages"})
+				// Let the writer flush the notice before cleanup closes the conn
+				<-client.done
+				break
+			}
+			if firstDrop && limiter.warning != "" {
+				slog.Debug("rate limiting client", "roomId", client.RoomID, "clientId", client.ID, "type", msg.Type)
+				client.Send(Message{Type: limiter.warning, RoomID: client.RoomID, Text: "Sending too fast, messages are being dropped"})
+			}
+			continue
+		}
+
+		if decodeErr != nil {
+			malformed++
+			slog.Warn("error unmarshaling message", "roomId", client.RoomID, "clientId", client.ID, "consecutive", malformed, "err", decodeErr)
+			if malformed >= maxMalformedMessages {
+				client.SendAndClose(Message{Type: "too-many-invalid-messages", RoomID: client.RoomID, Text: "Disconnected for sending too many malformed messages"})
+				<-client.done
+				break
+			}
+			perr := client.codec.invalid()
+			client.sendError(perr.Code, perr.Message)
+			continue
+		}
+		malformed = 0
+
+		now := time.Now()
+		msg.From = client.ID
+		msg.RoomID = client.RoomID
+		msg.Timestamp = &now
+		if !validCorrelationID(msg.CorrelationID) {
+			msg.CorrelationID = randomString(12)
+		}
+		if msg.Type == "set-quality" && msg.To == "" {
+			msg.To = msg.TargetClientID
+		}
+		slog.Debug("message received", "roomId", msg.RoomID, "clientId", msg.From, "msgType", msg.Type, "correlationId", msg.CorrelationID)
+		if msg.Type == "echo" {
+			// Application-level ping for checking the signaling path and
+			// measuring RTT. Only the sender hears back, and it works from
+			// the waiting room too. It isn't activity: a client that sends
+			// nothing but echoes still hits CLIENT_IDLE_TIMEOUT.
+			if perr := validateMessage(msg); perr != nil {
+				client.sendError(perr.Code, perr.Message)
+				continue
+			}
+			client.Send(Message{Type: "echo", RoomID: client.RoomID, Payload: msg.Payload, CorrelationID: msg.CorrelationID, Timestamp: msg.Timestamp})
+			continue
+		}
+		if !client.admitted.Load() {
+			client.sendError("not-admitted", "waiting for the host to admit you")
+			continue
+		}
+		countMessage(msg.Type)
+		room.touch()
+		client.touch()
+
+		if perr := validateMessage(msg); perr != nil {
+			slog.Debug("rejected message", "roomId", client.RoomID, "clientId", client.ID, "msgType", msg.Type, "correlationId", msg.CorrelationID, "code", perr.Code)
+			client.sendError(perr.Code, perr.Message)
+			continue
+		}
+
+		if len(msg.ToMany) > 0 {
+			// Multicast: same payload to each listed peer in one pass
+			missing := hub.forwardToMany(msg)
+			if negotiationMessageTypes[msg.Type] {
+				for _, id := range missing {
+					client.sendPeerUnavailable(msg, id)
+				}
+			} else if len(missing) > 0 {
+				client.sendError("peer-not-found", "no clients "+strings.Join(missing, ", ")+" in this room")
+			}
+			continue
+		}
+
+		// Handle different message types
+		switch msg.Type {
+		case "offer", "answer", "ice-candidate", "ice-restart", "renegotiate":
+			// Forward message to specific peer. ice-restart and renegotiate
+			// are explicit so the receiver can tell an ICE restart from a
+			// fresh negotiation without inspecting the SDP; either may
+			// carry the new offer in sdp.
+			if !hub.forwardMessage(msg) && !hub.forwardLinked(room, msg) {
+				client.sendPeerUnavailable(msg, msg.To)
+			}
+		case "relay", "set-quality":
+			// Pass-through for client protocol extensions such as data
+			// channel negotiation, and for receivers (or an SFU) asking a
+			// simulcast sender for another layer; neither is interpreted
+			if !hub.forwardMessage(msg) {
+				client.sendError("peer-not-found", "no client "+msg.To+" in this room")
+			}
+		case "update-settings":
+			hub.updateSettings(room, client, msg.Settings)
+		case "chat":
+			if !room.chatAllowed(client) {
+				client.ackChat(msg.MsgID, "chat-disabled", "chat is disabled in this room")
+				continue
+			}
+			if msg.To != "" {
+				// Private message: deliver to the target only and echo it
+				// back so the sender's UI shows it too
+				if !hub.forwardMessage(msg) {
+					client.ackChat(msg.MsgID, "peer-not-found", "no client "+msg.To+" in this room")
+					continue
+				}
+				client.Send(msg)
+				client.ackChat(msg.MsgID, "", "")
+				continue
+			}
+			// Broadcast chat message to everyone in the room. Only senders
+			// asking for an acknowledgement hear about lost copies.
+			if failed := hub.broadcastChat(room, msg); failed > 0 && msg.MsgID != "" {
+				client.ackChat(msg.MsgID, "chat-undelivered", "chat couldn't be delivered to every participant")
+			} else {
+				client.ackChat(msg.MsgID, "", "")
+			}
+		case "kick":
+			room.kick(client, msg.To)
+		case "transfer-host":
+			room.transferHost(client, msg.To)
+		case "link-room":
+			hub.linkRoom(room, client, strings.TrimSpace(msg.LinkedRoom))
+		case "accept-link":
+			hub.acceptLink(room, client, strings.TrimSpace(msg.LinkedRoom))
+		case "unlink-room":
+			hub.unlinkRoom(room, client, strings.TrimSpace(msg.LinkedRoom))
+		case "set-presenter":
+			hub.setPresenter(room, client, msg.To)
+		case "admit":
+			hub.admit(room, client, msg.To)
+		case "deny":
+			room.deny(client, msg.To)
+		case "media-state":
+			room.setMediaState(client, msg)
+		case "typing":
+			hub.setTyping(client, msg.IsTyping != nil && *msg.IsTyping)
+		case "screen-share":
+			room.setScreenShare(client, msg)
+		case "create-breakout":
+			room.createBreakout(client, msg.Breakout, msg.ClientIDs)
+		case "close-breakout":
+			room.closeBreakout(client, msg.Breakout)
+		case "recording-start", "recording-stop":
+			room.setRecording(client, msg.Type == "recording-start")
+		case "lock-room", "unlock-room":
+			room.setLocked(client, msg.Type == "lock-room")
+		case "set-welcome":
+			room.setWelcomeMessage(client, msg.Text)
+		case "rename":
+			room.rename(client, msg.Username)
+		case "get-room-info":
+			info := room.details()
+			client.Send(Message{Type: "room-info", RoomID: room.ID, CorrelationID: msg.CorrelationID, Room: &info})
+		case "request-roster":
+			hub.sendRoster(room, client, msg.CorrelationID)
+		case "get-features":
+			features := hub.serverFeatures()
+			client.Send(Message{Type: "features", RoomID: room.ID, CorrelationID: msg.CorrelationID, Features: &features})
+		case "stats-report":
+			room.reportStats(client, *msg.Stats)
+		case "raise-hand":
+			room.setRaisedHand(client, *msg.Raised)
+		case "file-share":
+			if !room.chatAllowed(client) {
+				client.sendError("chat-disabled", "chat is disabled in this room")
+				continue
+			}
+			// Relayed like chat so late joiners see shared files too
+			hub.broadcastChat(room, msg)
+		}
 	}
-	return string(b)
 }
-
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"video-conference-app/signaltest"
+)
+
+const testTimeout = 2 * time.Second
+
+// testSDP is the smallest session description validateSDP accepts
+const testSDP = "v=0\r\no=- 1 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"
+
+// TestMain keeps the server's logs out of test output unless run with -v
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+	os.Exit(m.Run())
+}
+
+// newTestServer serves a fresh hub with auth disabled for the length of
+// the test
+func newTestServer(t *testing.T, opts ...hubOption) (*signaltest.Server, *Hub) {
+	t.Helper()
+	hub := NewHub(append([]hubOption{withAuthDisabled()}, opts...)...)
+	return newServerForHub(t, hub), hub
+}
+
+// newServerForHub serves hub for the length of the test
+func newServerForHub(t *testing.T, hub *Hub) *signaltest.Server {
+	t.Helper()
+	srv := signaltest.NewServer(newRouter(hub))
+	t.Cleanup(func() {
+		srv.Close()
+		hub.shutdown()
+	})
+	return srv
+}
+
+// waitFor polls cond until it holds, failing the test if it doesn't
+// within testTimeout; the server cleans up after a disconnect
+// asynchronously
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func mustJoin(t *testing.T, srv *signaltest.Server, roomID, clientID string) *signaltest.Client {
+	t.Helper()
+	c, err := srv.Join(roomID, clientID)
+	if err != nil {
+		t.Fatalf("join %s: %v", clientID, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func mustExpect(t *testing.T, c *signaltest.Client, msgType string) signaltest.Message {
+	t.Helper()
+	msg, err := c.Expect(msgType, testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestJoinAndNegotiate(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := mustJoin(t, srv, "room-1", "alice")
+	bob := mustJoin(t, srv, "room-1", "bob")
+
+	if joined := mustExpect(t, alice, "join"); joined.From != "bob" {
+		t.Fatalf("join from %q, want bob", joined.From)
+	}
+	var state struct {
+		Host         string        `json:"host"`
+		Participants []Participant `json:"participants"`
+	}
+	if err := mustExpect(t, bob, "room-state").Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Host != "alice" || len(state.Participants) != 1 || state.Participants[0].ClientID != "alice" {
+		t.Fatalf("room-state = %+v, want host alice and alice as the only other participant", state)
+	}
+
+	offer := map[string]string{"type": "offer", "sdp": testSDP}
+	if err := alice.Send(map[string]any{"type": "offer", "to": "bob", "sdp": offer}); err != nil {
+		t.Fatal(err)
+	}
+	got := mustExpect(t, bob, "offer")
+	if got.From != "alice" {
+		t.Fatalf("offer from %q, want alice", got.From)
+	}
+	var desc map[string]string
+	if err := json.Unmarshal(got.SDP, &desc); err != nil || desc["sdp"] != testSDP {
+		t.Fatalf("offer sdp = %s, want it relayed unchanged", got.SDP)
+	}
+
+	answer := map[string]string{"type": "answer", "sdp": testSDP}
+	if err := bob.Send(map[string]any{"type": "answer", "to": "alice", "sdp": answer, "correlationId": got.CorrelationID}); err != nil {
+		t.Fatal(err)
+	}
+	reply := mustExpect(t, alice, "answer")
+	if reply.From != "bob" || reply.CorrelationID != got.CorrelationID {
+		t.Fatalf("answer from %q with correlationId %q, want bob and %q", reply.From, reply.CorrelationID, got.CorrelationID)
+	}
+}
+
+func TestChatBroadcast(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := mustJoin(t, srv, "room-1", "alice")
+	bob := mustJoin(t, srv, "room-1", "bob")
+	carol := mustJoin(t, srv, "room-1", "carol")
+
+	if err := alice.Send(map[string]any{"type": "chat", "message": "hello", "msgId": "m1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*signaltest.Client{bob, carol} {
+		msg := mustExpect(t, c, "chat")
+		if msg.From != "alice" || msg.Text != "hello" {
+			t.Fatalf("chat = %s, want alice's hello", msg.Raw)
+		}
+	}
+	// The sender is acknowledged instead of hearing its own message
+	for {
+		msg, err := alice.Receive(testTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == "chat" {
+			t.Fatalf("sender received its own chat: %s", msg.Raw)
+		}
+		if msg.Type == "chat-ack" {
+			if msg.MsgID != "m1" {
+				t.Fatalf("chat-ack msgId = %q, want m1", msg.MsgID)
+			}
+			break
+		}
+	}
+}
+
+func TestLeaveNotification(t *testing.T) {
+	srv, _ := newTestServer(t)
+	alice := mustJoin(t, srv, "room-1", "alice")
+	bob := mustJoin(t, srv, "room-1", "bob")
+	mustExpect(t, alice, "join")
+
+	bob.Close()
+	if left := mustExpect(t, alice, "leave"); left.From != "bob" {
+		t.Fatalf("leave from %q, want bob", left.From)
+	}
+
+	// The host leaving hands the room to whoever remains
+	carol := mustJoin(t, srv, "room-1", "carol")
+	alice.Close()
+	mustExpect(t, carol, "leave")
+	if changed := mustExpect(t, carol, "host-changed"); changed.Host != "carol" {
+		t.Fatalf("new host %q, want carol", changed.Host)
+	}
+}
+
+func TestRoomPassword(t *testing.T) {
+	srv, _ := newTestServer(t)
+	resp, err := http.Post(srv.URL+"/api/rooms", "application/json", strings.NewReader(`{"name":"locked-1","password":"s3cret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("creating room: status %d", resp.StatusCode)
+	}
+
+	query := url.Values{"roomId": {"locked-1"}, "clientId": {"alice"}, "username": {"alice"}}
+	for _, password := range []string{"", "wrong"} {
+		query.Set("password", password)
+		conn, resp, err := websocket.DefaultDialer.Dial(srv.WebSocketURL(query), nil)
+		if err == nil {
+			conn.Close()
+			t.Fatalf("password %q: upgraded, want 401", password)
+		}
+		if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("password %q: %v, want 401", password, err)
+		}
+	}
+
+	query.Set("password", "s3cret")
+	alice, err := srv.Dial(query)
+	if err != nil {
+		t.Fatalf("correct password: %v", err)
+	}
+	defer alice.Close()
+	mustExpect(t, alice, "joined")
+}
+
+func TestShutdownClosesStalledClientsInTime(t *testing.T) {
+	const wait = time.Second
+	_, hub := newTestServer(t, withWriteWait(wait))
+	var conns []*stalledConn
+	for _, id := range []string{"bob", "carol", "dave"} {
+		conns = append(conns, serveStalled(hub, "room-1", id))
+	}
+	waitFor(t, "the stalled clients to join", func() bool {
+		room, ok := hub.GetRoom("room-1")
+		return ok && room.summary().ClientCount == len(conns)
+	})
+
+	// None of them will take a close frame, which must not hold shutdown
+	// past its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	closeAllClients(ctx, hub)
+	if elapsed := time.Since(start); elapsed > wait {
+		t.Fatalf("closing %d stalled clients took %v", len(conns), elapsed)
+	}
+	for i, conn := range conns {
+		if !conn.isClosed() {
+			t.Fatalf("stalled connection %d left open after shutdown", i)
+		}
+	}
+}
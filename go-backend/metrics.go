@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	roomsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vc_rooms_active",
+		Help: "Number of rooms currently held in memory.",
+	})
+	clientsConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vc_clients_connected",
+		Help: "Number of websocket clients currently in a room.",
+	})
+	messagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vc_messages_total",
+		Help: "Signaling and chat messages received from clients, by type.",
+	}, []string{"type"})
+	messagesDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vc_messages_dropped_total",
+		Help: "Droppable outbound messages discarded because a client's send buffer was backing up, by type.",
+	}, []string{"type"})
+	transcriptDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "vc_transcript_dropped_total",
+		Help: "Chat messages left out of transcripts because the writer fell behind.",
+	})
+	webhooksFailedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "vc_webhooks_failed_total",
+		Help: "Webhook events dropped from a full queue or not delivered after all retries.",
+	})
+	roomsReapedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vc_rooms_reaped_total",
+		Help: "Idle rooms removed by garbage collection, by trigger (janitor or manual).",
+	}, []string{"trigger"})
+	roomGCRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vc_room_gc_runs_total",
+		Help: "Idle-room garbage collection sweeps, by trigger (janitor or manual).",
+	}, []string{"trigger"})
+	roomGCLastRun = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vc_room_gc_last_run_timestamp_seconds",
+		Help: "Unix time of the last idle-room garbage collection sweep.",
+	})
+	roomEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vc_room_events_total",
+		Help: "Client join, reconnect and leave events.",
+	}, []string{"event"})
+)
+
+// countedMessageTypes bounds the label cardinality of messagesTotal;
+// anything else is counted as "other"
+var countedMessageTypes = map[string]bool{
+	"offer":         true,
+	"answer":        true,
+	"ice-candidate": true,
+	"chat":          true,
+}
+
+func countMessage(msgType string) {
+	countMessageType(msgType)
+	if !countedMessageTypes[msgType] {
+		msgType = "other"
+	}
+	messagesTotal.WithLabelValues(msgType).Inc()
+}
@@ -0,0 +1,36 @@
+package main
+
+// shouldOffer decides which of two peers initiates the WebRTC offer, so
+// both sides agree without racing into glare. The peer with the
+// lexicographically smaller clientId offers and acts as the impolite peer
+// in perfect negotiation; the other one answers and yields on collisions.
+func shouldOffer(self, peer string) bool {
+	return self < peer
+}
+
+// negotiationMessageTypes are the WebRTC signals whose sender keeps a peer
+// connection waiting on the target
+var negotiationMessageTypes = map[string]bool{
+	"offer":         true,
+	"answer":        true,
+	"ice-candidate": true,
+	"ice-restart":   true,
+	"renegotiate":   true,
+}
+
+// sendPeerUnavailable tells client that msg could not reach peerID, which
+// has left or is leaving, so it can tear down that peer connection instead
+// of waiting for an answer that will never come. From names the peer, as
+// in leave.
+func (c *Client) sendPeerUnavailable(msg Message, peerID string) {
+	c.Send(Message{Type: "peer-unavailable", From: peerID, RoomID: c.RoomID, CorrelationID: msg.CorrelationID})
+}
+
+// assignOfferers marks, from self's point of view, which participants
+// self must send the offer to
+func assignOfferers(self string, participants []Participant) {
+	for i := range participants {
+		offerer := shouldOffer(self, participants[i].ClientID)
+		participants[i].Offerer = &offerer
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// statsReportInterval is the minimum gap between stats reports the server
+// acts on per client; clients typically send them every second
+var statsReportInterval = time.Duration(envInt("STATS_REPORT_INTERVAL_SECONDS", 5)) * time.Second
+
+// simulcastLayers are the encodings a set-quality message may ask a sender
+// for. The server only relays the request; the sender picks the layer.
+var simulcastLayers = map[string]bool{
+	"low":    true,
+	"medium": true,
+	"high":   true,
+}
+
+// ConnectionStats is the WebRTC quality summary a client reports in a
+// stats-report message
+type ConnectionStats struct {
+	// PacketLoss is the percentage of packets lost, 0-100
+	PacketLoss float64 `json:"packetLoss"`
+	// Jitter and RTT are in milliseconds
+	Jitter float64 `json:"jitter"`
+	RTT    float64 `json:"rtt"`
+}
+
+// quality buckets the stats into good, fair or poor using the worst of
+// the three metrics
+func (s ConnectionStats) quality() string {
+	switch {
+	case s.PacketLoss >= 5 || s.Jitter >= 50 || s.RTT >= 400:
+		return "poor"
+	case s.PacketLoss >= 1 || s.Jitter >= 20 || s.RTT >= 150:
+		return "fair"
+	default:
+		return "good"
+	}
+}
+
+// valid reports whether the metrics are in range
+func (s ConnectionStats) valid() bool {
+	return s.PacketLoss >= 0 && s.PacketLoss <= 100 && s.Jitter >= 0 && s.RTT >= 0
+}
+
+// reportStats records client's latest connection stats. Reports arriving
+// faster than statsReportInterval are ignored, and the room only hears
+// about the derived quality level when it changes; the raw stats are
+// never rebroadcast.
+func (r *Room) reportStats(client *Client, stats ConnectionStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(client.statsAt) < statsReportInterval {
+		return
+	}
+	client.statsAt = now
+	client.Stats = stats
+
+	quality := stats.quality()
+	if quality == client.Quality {
+		return
+	}
+	client.Quality = quality
+	slog.Debug("connection quality changed", "roomId", r.ID, "clientId", client.ID, "quality", quality)
+	r.broadcastLocked(Message{Type: "connection-quality", From: client.ID, RoomID: r.ID, Quality: quality})
+}
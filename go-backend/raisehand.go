@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+)
+
+// setRaisedHand raises or lowers client's hand. Raised hands are kept in
+// the order they went up so the host can take questions in turn; raising
+// an already raised hand keeps its place in the queue.
+func (r *Room) setRaisedHand(client *Client, raised bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.Index(r.RaisedHands, client.ID)
+	switch {
+	case raised && i < 0:
+		r.RaisedHands = append(r.RaisedHands, client.ID)
+	case !raised && i >= 0:
+		r.RaisedHands = slices.Delete(r.RaisedHands, i, i+1)
+	default:
+		return
+	}
+	slog.Info("raised hand changed", "event", "raise-hand", "roomId", r.ID, "clientId", client.ID, "raised", raised)
+	r.broadcastLocked(Message{Type: "raise-hand", From: client.ID, RoomID: r.ID, Raised: &raised})
+}
+
+// clearRaisedHandLocked lowers client's hand, if raised, and tells the
+// room. Used when the client leaves. The caller must hold r.mu.
+func (r *Room) clearRaisedHandLocked(client *Client) {
+	i := slices.Index(r.RaisedHands, client.ID)
+	if i < 0 {
+		return
+	}
+	r.RaisedHands = slices.Delete(r.RaisedHands, i, i+1)
+	raised := false
+	r.broadcastLocked(Message{Type: "raise-hand", From: client.ID, RoomID: r.ID, Raised: &raised})
+}
+
+// raisedHandsLocked returns a copy of the raise-hand queue for room-state.
+// The caller must hold r.mu.
+func (r *Room) raisedHandsLocked() []string {
+	return slices.Clone(r.RaisedHands)
+}
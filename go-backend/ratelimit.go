@@ -0,0 +1,85 @@
+package main
+
+import "golang.org/x/time/rate"
+
+var (
+	// rateLimitPerSecond and rateLimitBurst cover signaling and every other
+	// message type without a category of its own; bursts of ICE candidates
+	// are normal here
+	rateLimitPerSecond = envInt("RATE_LIMIT_PER_SECOND", 50)
+	rateLimitBurst     = envInt("RATE_LIMIT_BURST", 100)
+	// chatRateLimitPerSecond and chatRateLimitBurst cover chat and file
+	// shares, which nobody legitimately sends many of
+	chatRateLimitPerSecond = envInt("CHAT_RATE_LIMIT_PER_SECOND", 5)
+	chatRateLimitBurst     = envInt("CHAT_RATE_LIMIT_BURST", 10)
+	// statsRateLimitPerSecond and statsRateLimitBurst cover stats-report,
+	// which clients send on a timer
+	statsRateLimitPerSecond = envInt("STATS_RATE_LIMIT_PER_SECOND", 2)
+	statsRateLimitBurst     = envInt("STATS_RATE_LIMIT_BURST", 5)
+	// rateLimitMaxViolations is how many messages in a row may be dropped
+	// before the client is disconnected for flooding
+	rateLimitMaxViolations = envInt("RATE_LIMIT_MAX_VIOLATIONS", 100)
+)
+
+// messageLimiter is a per-connection token bucket. It is only used from
+// the connection's read goroutine, so it needs no locking.
+type messageLimiter struct {
+	limiter    *rate.Limiter
+	violations int
+	// warning is the message type sent on the first drop of a run; empty
+	// drops quietly
+	warning string
+}
+
+func newMessageLimiter(perSecond, burst int, warning string) *messageLimiter {
+	return &messageLimiter{
+		limiter: rate.NewLimiter(rate.Limit(perSecond), burst),
+		warning: warning,
+	}
+}
+
+// allow reports whether the next message may be processed. A dropped
+// message counts as a violation; the count resets once a message gets
+// through. firstDrop is set on the first drop of a run so the caller can
+// warn the client once rather than for every message.
+func (l *messageLimiter) allow() (ok, firstDrop bool) {
+	if l.limiter.Allow() {
+		l.violations = 0
+		return true, false
+	}
+	l.violations++
+	return false, l.violations == 1
+}
+
+// exceeded reports whether the client has kept flooding long enough to be
+// disconnected
+func (l *messageLimiter) exceeded() bool {
+	return l.violations > rateLimitMaxViolations
+}
+
+// clientLimiters holds a connection's bucket per message category, so a
+// chat flood is throttled without holding up the client's signaling
+type clientLimiters struct {
+	signaling *messageLimiter
+	chat      *messageLimiter
+	stats     *messageLimiter
+}
+
+func newClientLimiters() *clientLimiters {
+	return &clientLimiters{
+		signaling: newMessageLimiter(rateLimitPerSecond, rateLimitBurst, "rate-limited"),
+		chat:      newMessageLimiter(chatRateLimitPerSecond, chatRateLimitBurst, "chat-rate-limited"),
+		stats:     newMessageLimiter(statsRateLimitPerSecond, statsRateLimitBurst, ""),
+	}
+}
+
+// forType returns the bucket a message of msgType is charged to
+func (l *clientLimiters) forType(msgType string) *messageLimiter {
+	switch msgType {
+	case "chat", "file-share":
+		return l.chat
+	case "stats-report":
+		return l.stats
+	}
+	return l.signaling
+}
@@ -0,0 +1,35 @@
+package main
+
+import "log/slog"
+
+// setRecording starts or stops the room's recording indicator. Only the
+// host may change it, and starting an already running recording is
+// rejected so two recorders can't race. Everyone is told the new state so
+// their UI can show consent indicators.
+func (r *Room) setRecording(client *Client, recording bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case r.Host != client.ID:
+		client.sendError("not-host", "only the host can control recording")
+		return
+	case recording && r.Recording:
+		client.sendError("recording-active", "the room is already being recorded")
+		return
+	case !recording && !r.Recording:
+		return
+	}
+
+	r.Recording = recording
+	slog.Info("recording changed", "event", "recording", "roomId", r.ID, "clientId", client.ID, "recording", recording)
+	if recording {
+		r.webhooks.emit(webhookRecordingStarted, r, client.ID)
+	} else {
+		r.webhooks.emit(webhookRecordingStopped, r, client.ID)
+	}
+	msg := Message{Type: "recording-state", From: client.ID, RoomID: r.ID, Recording: &recording}
+	r.broadcastLocked(msg)
+	// Echo to the host as confirmation
+	client.Send(msg)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis lets several signaling instances share rooms. Each instance keeps
+// its own websocket clients in memory; Redis records which instance every
+// client is connected to and carries messages between instances over
+// pub/sub. Room-level state such as host, chat history and media flags
+// stays local to each instance.
+//
+// Every instance keeps a liveness key, vc:instance:<id>, alive with a
+// heartbeat. Registry entries name their instance, so the members of an
+// instance that crashed without unregistering them are recognised by its
+// expired key, skipped and cleaned up.
+const (
+	redisSignalPrefix   = "vc:signal:"
+	redisClientsPrefix  = "vc:clients:"
+	redisInstancePrefix = "vc:instance:"
+)
+
+// redisInstanceTTL is how long an instance counts as alive after its last
+// heartbeat; heartbeats come every third of it
+const redisInstanceTTL = 30 * time.Second
+
+// redisDeleteIfUnchanged removes a registry entry only if it still holds
+// the value read, so cleaning up a dead instance's member can't delete it
+// after it reconnected elsewhere
+var redisDeleteIfUnchanged = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return 0`)
+
+// publishBufferSize bounds how many outbound envelopes may wait for Redis
+// before new ones are dropped
+const publishBufferSize = 1024
+
+// redisEnvelope wraps a message published to other instances
+type redisEnvelope struct {
+	Origin  string  `json:"origin"`
+	Forward bool    `json:"forward,omitempty"`
+	Message Message `json:"message"`
+}
+
+// redisMember is the registry entry for one client
+type redisMember struct {
+	Instance string `json:"instance"`
+	Username string `json:"username"`
+	Service  bool   `json:"service,omitempty"`
+	// Capabilities mirrors Client.Capabilities for remote rosters
+	Capabilities json.RawMessage `json:"capabilities,omitempty"`
+}
+
+// redisBus relays room traffic between instances. A nil *redisBus is
+// valid and does nothing, which is the in-memory-only mode.
+type redisBus struct {
+	client     *redis.Client
+	instanceID string
+	outbound   chan redisEnvelope
+}
+
+// newRedisBus connects to Redis and starts relaying messages for hub
+// until ctx is canceled
+func newRedisBus(ctx context.Context, url string, hub *Hub) (*redisBus, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	b := &redisBus{
+		client:     client,
+		instanceID: randomString(12),
+		outbound:   make(chan redisEnvelope, publishBufferSize),
+	}
+	if err := b.heartbeat(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	go b.heartbeatLoop(ctx)
+	go b.publishLoop(ctx)
+	go b.subscribeLoop(ctx, hub)
+	return b, nil
+}
+
+// heartbeat marks this instance alive for another redisInstanceTTL
+func (b *redisBus) heartbeat(ctx context.Context) error {
+	return b.client.Set(ctx, redisInstancePrefix+b.instanceID, time.Now().Unix(), redisInstanceTTL).Err()
+}
+
+// heartbeatLoop keeps the liveness key fresh until ctx is canceled, then
+// deletes it so peers stop counting this instance's members straight away
+func (b *redisBus) heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(redisInstanceTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.client.Del(context.Background(), redisInstancePrefix+b.instanceID)
+			return
+		case <-ticker.C:
+			if err := b.heartbeat(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("error renewing redis instance heartbeat", "instance", b.instanceID, "err", err)
+			}
+		}
+	}
+}
+
+// instanceAlive reports whether instanceID's liveness key is current. On
+// errors it assumes the instance is alive rather than drop its members.
+func (b *redisBus) instanceAlive(ctx context.Context, instanceID string) bool {
+	n, err := b.client.Exists(ctx, redisInstancePrefix+instanceID).Result()
+	if err != nil {
+		slog.Warn("error checking redis instance liveness", "instance", instanceID, "err", err)
+		return true
+	}
+	return n > 0
+}
+
+// dropStale removes clientID's registry entry under key, last read as raw,
+// left behind by an instance that is no longer alive
+func (b *redisBus) dropStale(ctx context.Context, key, clientID, raw, instanceID string) {
+	slog.Info("removing member of dead instance", "key", key, "clientId", clientID, "instance", instanceID)
+	if err := redisDeleteIfUnchanged.Run(ctx, b.client, []string{key}, clientID, raw).Err(); err != nil {
+		slog.Warn("error removing stale redis room member", "key", key, "clientId", clientID, "err", err)
+	}
+}
+
+// publishBroadcast relays a room-wide message to other instances
+func (b *redisBus) publishBroadcast(msg Message) {
+	b.enqueue(redisEnvelope{Message: msg})
+}
+
+// publishForward relays a message for a client on another instance
+func (b *redisBus) publishForward(msg Message) {
+	b.enqueue(redisEnvelope{Forward: true, Message: msg})
+}
+
+// enqueue hands env to the publisher without blocking the caller, which
+// is often holding a room lock
+func (b *redisBus) enqueue(env redisEnvelope) {
+	if b == nil {
+		return
+	}
+	env.Origin = b.instanceID
+	env.Message.closeAfter = false
+	select {
+	case b.outbound <- env:
+	default:
+		slog.Warn("redis publish buffer full, dropping message", "roomId", env.Message.RoomID, "msgType", env.Message.Type)
+	}
+}
+
+func (b *redisBus) publishLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case env := <-b.outbound:
+			payload, err := json.Marshal(env)
+			if err != nil {
+				slog.Error("error marshaling redis envelope", "roomId", env.Message.RoomID, "err", err)
+				continue
+			}
+			if err := b.client.Publish(ctx, redisSignalPrefix+env.Message.RoomID, payload).Err(); err != nil {
+				slog.Warn("error publishing to redis", "roomId", env.Message.RoomID, "msgType", env.Message.Type, "err", err)
+			}
+		}
+	}
+}
+
+func (b *redisBus) subscribeLoop(ctx context.Context, hub *Hub) {
+	sub := b.client.PSubscribe(ctx, redisSignalPrefix+"*")
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		var env redisEnvelope
+		if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+			slog.Warn("error unmarshaling redis envelope", "channel", msg.Channel, "err", err)
+			continue
+		}
+		if env.Origin == b.instanceID {
+			continue
+		}
+		roomID := strings.TrimPrefix(msg.Channel, redisSignalPrefix)
+		b.deliver(hub, roomID, env)
+	}
+}
+
+// deliver hands a message from another instance to local clients only
+func (b *redisBus) deliver(hub *Hub, roomID string, env redisEnvelope) {
+	room, exists := hub.GetRoom(roomID)
+	if !exists {
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if env.Forward {
+		if target, ok := room.Clients[env.Message.To]; ok {
+			target.Send(env.Message)
+		}
+		return
+	}
+	room.deliverLocked(env.Message)
+}
+
+// registerClient records that client is connected to this instance
+func (b *redisBus) registerClient(client *Client) {
+	if b == nil {
+		return
+	}
+	member, _ := json.Marshal(redisMember{Instance: b.instanceID, Username: client.Username, Service: client.Service, Capabilities: client.Capabilities})
+	if err := b.client.HSet(context.Background(), redisClientsPrefix+client.RoomID, client.ID, member).Err(); err != nil {
+		slog.Warn("error registering client in redis", "roomId", client.RoomID, "clientId", client.ID, "err", err)
+	}
+}
+
+// unregisterClient removes client from the registry if this instance
+// still owns the entry (it may have reconnected elsewhere)
+func (b *redisBus) unregisterClient(client *Client) {
+	if b == nil {
+		return
+	}
+	ctx := context.Background()
+	key := redisClientsPrefix + client.RoomID
+	if member, ok := b.lookup(ctx, key, client.ID); ok && member.Instance == b.instanceID {
+		if err := b.client.HDel(ctx, key, client.ID).Err(); err != nil {
+			slog.Warn("error unregistering client in redis", "roomId", client.RoomID, "clientId", client.ID, "err", err)
+		}
+	}
+}
+
+// isRemote reports whether clientID is in roomID on another instance
+// that is still alive
+func (b *redisBus) isRemote(roomID, clientID string) bool {
+	if b == nil {
+		return false
+	}
+	ctx := context.Background()
+	key := redisClientsPrefix + roomID
+	raw, member, ok := b.lookupRaw(ctx, key, clientID)
+	if !ok || member.Instance == b.instanceID {
+		return false
+	}
+	if !b.instanceAlive(ctx, member.Instance) {
+		b.dropStale(ctx, key, clientID, raw, member.Instance)
+		return false
+	}
+	return true
+}
+
+// remoteParticipants lists the members of roomID connected to other
+// instances
+func (b *redisBus) remoteParticipants(roomID string) []Participant {
+	if b == nil {
+		return nil
+	}
+	ctx := context.Background()
+	key := redisClientsPrefix + roomID
+	entries, err := b.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		slog.Warn("error listing redis room members", "roomId", roomID, "err", err)
+		return nil
+	}
+
+	var participants []Participant
+	alive := make(map[string]bool)
+	for clientID, raw := range entries {
+		var member redisMember
+		if json.Unmarshal([]byte(raw), &member) != nil || member.Instance == b.instanceID {
+			continue
+		}
+		live, checked := alive[member.Instance]
+		if !checked {
+			live = b.instanceAlive(ctx, member.Instance)
+			alive[member.Instance] = live
+		}
+		if !live {
+			b.dropStale(ctx, key, clientID, raw, member.Instance)
+			continue
+		}
+		if member.Service {
+			continue
+		}
+		participants = append(participants, Participant{
+			ClientID:     clientID,
+			Username:     member.Username,
+			AudioEnabled: true,
+			VideoEnabled: true,
+			Capabilities: member.Capabilities,
+		})
+	}
+	return participants
+}
+
+func (b *redisBus) lookup(ctx context.Context, key, clientID string) (redisMember, bool) {
+	_, member, ok := b.lookupRaw(ctx, key, clientID)
+	return member, ok
+}
+
+// lookupRaw is lookup that also returns the entry as stored
+func (b *redisBus) lookupRaw(ctx context.Context, key, clientID string) (string, redisMember, bool) {
+	var member redisMember
+	raw, err := b.client.HGet(ctx, key, clientID).Result()
+	if err != nil {
+		if err != redis.Nil {
+			slog.Warn("error reading redis room member", "key", key, "clientId", clientID, "err", err)
+		}
+		return "", member, false
+	}
+	return raw, member, json.Unmarshal([]byte(raw), &member) == nil
+}
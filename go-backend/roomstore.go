@@ -0,0 +1,91 @@
+package main
+
+import "sync"
+
+// RoomStore holds the set of active rooms, so deployments can keep room
+// metadata somewhere other than process memory. Implementations must be
+// safe for concurrent use. The hub serializes compound operations such as
+// check-then-create with its own lock, so a store only has to make each
+// call atomic on its own.
+//
+// A store holds live *Room values, with their locks, timers and connected
+// clients, and Get must return the same *Room that was created, so it
+// can't be backed by a database alone: nothing read back from Postgres or
+// Redis could carry a connection. A database-backed store instead wraps an
+// in-process one such as memoryRoomStore, writing room metadata through to
+// the database on Create and Delete while serving Get and List from
+// memory. It only ever sees this instance's rooms; rooms on other
+// instances are reached through the Redis bus, see redis.go.
+//
+// For the same reason per-room client operations stay on Room rather than
+// here: clients are websocket connections owned by this process, whatever
+// the store.
+type RoomStore interface {
+	// Create registers room under room.ID, failing with errRoomExists if
+	// the ID is taken
+	Create(room *Room) error
+	// Get looks up a room by ID
+	Get(roomID string) (*Room, bool)
+	// Delete removes the room with the given ID, if any
+	Delete(roomID string)
+	// List returns a snapshot of all rooms
+	List() []*Room
+	// Count returns how many rooms are stored
+	Count() int
+}
+
+// memoryRoomStore is the default RoomStore, a map guarded by a mutex
+type memoryRoomStore struct {
+	mu    sync.Mutex
+	rooms map[string]*Room
+}
+
+var _ RoomStore = (*memoryRoomStore)(nil)
+
+func newMemoryRoomStore() *memoryRoomStore {
+	return &memoryRoomStore{rooms: make(map[string]*Room)}
+}
+
+func (s *memoryRoomStore) Create(room *Room) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, taken := s.rooms[room.ID]; taken {
+		return errRoomExists
+	}
+	s.rooms[room.ID] = room
+	return nil
+}
+
+func (s *memoryRoomStore) Get(roomID string) (*Room, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room, exists := s.rooms[roomID]
+	return room, exists
+}
+
+func (s *memoryRoomStore) Delete(roomID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.rooms, roomID)
+}
+
+func (s *memoryRoomStore) List() []*Room {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+func (s *memoryRoomStore) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.rooms)
+}
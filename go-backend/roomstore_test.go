@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// testRoomStore checks the RoomStore contract against a fresh store
+// from newStore
+func testRoomStore(t *testing.T, newStore func() RoomStore) {
+	t.Run("CreateGet", func(t *testing.T) {
+		store := newStore()
+		room := newRoom()
+		room.ID = "room-1"
+		if err := store.Create(room); err != nil {
+			t.Fatal(err)
+		}
+		got, ok := store.Get("room-1")
+		if !ok || got != room {
+			t.Fatalf("Get returned %p, %v; want the created room %p", got, ok, room)
+		}
+		if _, ok := store.Get("room-2"); ok {
+			t.Fatal("Get found a room that was never created")
+		}
+	})
+
+	t.Run("CreateTaken", func(t *testing.T) {
+		store := newStore()
+		first, second := newRoom(), newRoom()
+		first.ID, second.ID = "room-1", "room-1"
+		if err := store.Create(first); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Create(second); !errors.Is(err, errRoomExists) {
+			t.Fatalf("second Create = %v, want errRoomExists", err)
+		}
+		if got, _ := store.Get("room-1"); got != first {
+			t.Fatal("a failed Create replaced the existing room")
+		}
+	})
+
+	t.Run("DeleteListCount", func(t *testing.T) {
+		store := newStore()
+		for _, id := range []string{"a", "b", "c"} {
+			room := newRoom()
+			room.ID = id
+			if err := store.Create(room); err != nil {
+				t.Fatal(err)
+			}
+		}
+		store.Delete("b")
+		store.Delete("missing")
+		var ids []string
+		for _, room := range store.List() {
+			ids = append(ids, room.ID)
+		}
+		slices.Sort(ids)
+		if !slices.Equal(ids, []string{"a", "c"}) || store.Count() != 2 {
+			t.Fatalf("List = %v and Count = %d after deleting b, want [a c] and 2", ids, store.Count())
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		store := newStore()
+		var wg sync.WaitGroup
+		var created sync.Map
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				room := newRoom()
+				room.ID = "contested"
+				if store.Create(room) == nil {
+					created.Store(room, true)
+				}
+				store.List()
+				store.Count()
+			}()
+		}
+		wg.Wait()
+		var winners int
+		created.Range(func(any, any) bool { winners++; return true })
+		if winners != 1 || store.Count() != 1 {
+			t.Fatalf("%d concurrent Creates of one ID succeeded and Count = %d, want 1 and 1", winners, store.Count())
+		}
+	})
+}
+
+func TestMemoryRoomStore(t *testing.T) {
+	testRoomStore(t, func() RoomStore { return newMemoryRoomStore() })
+}
+
+// writeThroughStore is the shape of a database-backed store: rooms live
+// in memory and only their IDs are written through, here to a slice
+type writeThroughStore struct {
+	*memoryRoomStore
+	mu      sync.Mutex
+	written []string
+}
+
+var _ RoomStore = (*writeThroughStore)(nil)
+
+func (s *writeThroughStore) Create(room *Room) error {
+	if err := s.memoryRoomStore.Create(room); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = append(s.written, room.ID)
+	return nil
+}
+
+func (s *writeThroughStore) Delete(roomID string) {
+	s.memoryRoomStore.Delete(roomID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = slices.DeleteFunc(s.written, func(id string) bool { return id == roomID })
+}
+
+func TestWriteThroughRoomStore(t *testing.T) {
+	testRoomStore(t, func() RoomStore { return &writeThroughStore{memoryRoomStore: newMemoryRoomStore()} })
+}
+
+func TestHubUsesRoomStore(t *testing.T) {
+	store := &writeThroughStore{memoryRoomStore: newMemoryRoomStore()}
+	hub := NewHubWithStore(store, withAuthDisabled())
+	srv := newServerForHub(t, hub)
+
+	alice := mustJoin(t, srv, "room-1", "alice")
+	store.mu.Lock()
+	written := slices.Clone(store.written)
+	store.mu.Unlock()
+	if !slices.Equal(written, []string{"room-1"}) {
+		t.Fatalf("store holds %v after the first join, want [room-1]", written)
+	}
+
+	alice.Close()
+	waitFor(t, "the empty room to leave the store", func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.written) == 0 && store.Count() == 0
+	})
+}
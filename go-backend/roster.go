@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// rosterSyncInterval, when set, sends every client a roster-sync this
+// often, so rosters that drifted through a missed join or leave heal on
+// their own. Zero, the default, only sends one on request-roster.
+var rosterSyncInterval = time.Duration(envInt("ROSTER_SYNC_INTERVAL_SECONDS", 0)) * time.Second
+
+// rosterSync is the authoritative roster message for viewer. Like
+// room-state it leaves viewer itself out, and Participants is omitted
+// when viewer is alone.
+func rosterSync(room *Room, viewer *Client, host string, participants []Participant, correlationID string) Message {
+	assignOfferers(viewer.ID, participants)
+	return Message{Type: "roster-sync", RoomID: room.ID, Host: host, Participants: participants, CorrelationID: correlationID}
+}
+
+// sendRoster answers request-roster with the room's current participants,
+// including those connected to other instances
+func (h *Hub) sendRoster(room *Room, client *Client, correlationID string) {
+	room.mu.Lock()
+	participants := room.rosterLocked(client)
+	host := room.Host
+	room.mu.Unlock()
+
+	participants = append(participants, h.bus.remoteParticipants(room.ID)...)
+	client.Send(rosterSync(room, client, host, participants, correlationID))
+}
+
+// runRosterSync sends every admitted client its roster each
+// rosterSyncInterval until ctx is canceled
+func (h *Hub) runRosterSync(ctx context.Context) {
+	ticker := time.NewTicker(rosterSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, room := range h.ListRooms() {
+				h.syncRoster(room)
+			}
+		}
+	}
+}
+
+// syncRoster sends each of room's members its roster
+func (h *Hub) syncRoster(room *Room) {
+	room.mu.Lock()
+	members := make([]*Client, 0, len(room.Clients))
+	rosters := make([][]Participant, 0, len(room.Clients))
+	for _, c := range room.Clients {
+		members = append(members, c)
+		rosters = append(rosters, room.rosterLocked(c))
+	}
+	host := room.Host
+	room.mu.Unlock()
+
+	if len(members) == 0 {
+		return
+	}
+	remote := h.bus.remoteParticipants(room.ID)
+	for i, c := range members {
+		c.Send(rosterSync(room, c, host, append(rosters[i], remote...), ""))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import "log/slog"
+
+// ScreenShare describes the active screen share in a room-state snapshot
+type ScreenShare struct {
+	ClientID string `json:"clientId"`
+	StreamID string `json:"streamId,omitempty"`
+}
+
+// setScreenShare starts or stops client's screen share. Only one
+// participant may share at a time: a second start is rejected rather than
+// silently superseding the current presenter, and only the presenter can
+// stop its own share.
+func (r *Room) setScreenShare(client *Client, msg Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sharing := *msg.Sharing
+	switch {
+	case sharing && r.ScreenSharer != "" && r.ScreenSharer != client.ID:
+		client.sendError("screen-share-active", "another participant is already sharing their screen")
+		return
+	case !sharing && r.ScreenSharer != client.ID:
+		return
+	}
+
+	if sharing {
+		r.ScreenSharer, r.ScreenStreamID = client.ID, msg.StreamID
+	} else {
+		r.ScreenSharer, r.ScreenStreamID = "", ""
+	}
+	slog.Info("screen share changed", "event", "screen-share", "roomId", r.ID, "clientId", client.ID, "sharing", sharing)
+	r.broadcastLocked(Message{
+		Type:     "screen-share",
+		From:     client.ID,
+		RoomID:   r.ID,
+		Sharing:  &sharing,
+		StreamID: msg.StreamID,
+	})
+}
+
+// clearScreenShareLocked ends client's screen share, if it has one, and
+// tells the room. Used when the presenter leaves. The caller must hold r.mu.
+func (r *Room) clearScreenShareLocked(client *Client) {
+	if r.ScreenSharer != client.ID {
+		return
+	}
+	r.ScreenSharer, r.ScreenStreamID = "", ""
+	sharing := false
+	r.broadcastLocked(Message{Type: "screen-share", From: client.ID, RoomID: r.ID, Sharing: &sharing})
+}
+
+// screenShareLocked describes the active share for room-state, or nil.
+// The caller must hold r.mu.
+func (r *Room) screenShareLocked() *ScreenShare {
+	if r.ScreenSharer == "" {
+		return nil
+	}
+	return &ScreenShare{ClientID: r.ScreenSharer, StreamID: r.ScreenStreamID}
+}
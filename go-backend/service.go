@@ -0,0 +1,34 @@
+package main
+
+// Service clients are infrastructure peers such as an SFU or a recording
+// bot. They join with ?service=true and the admin API key, receive every
+// broadcast in the room, and are otherwise invisible: they are left out of
+// rosters and counts, join and leave silently, never become host and don't
+// take a seat from the room's maxClients.
+
+// participantCountLocked is how many non-service clients are in the room.
+// The caller must hold r.mu.
+func (r *Room) participantCountLocked() int {
+	n := 0
+	for _, c := range r.Clients {
+		if !c.Service {
+			n++
+		}
+	}
+	return n
+}
+
+// rosterLocked lists the room's participants as seen by viewer: everyone
+// but the viewer itself, and, unless the viewer is a service client, no
+// service clients. A nil viewer gets the public roster. The caller must
+// hold r.mu.
+func (r *Room) rosterLocked(viewer *Client) []Participant {
+	participants := make([]Participant, 0, len(r.Clients))
+	for _, c := range r.Clients {
+		if c == viewer || (c.Service && (viewer == nil || !viewer.Service)) {
+			continue
+		}
+		participants = append(participants, c.participant())
+	}
+	return participants
+}
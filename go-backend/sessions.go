@@ -0,0 +1,41 @@
+package main
+
+import "log/slog"
+
+// duplicateSessionPolicy decides what happens when a user who is already
+// in a room joins it again from another connection (typically a second
+// tab): "notify" tells the existing connections so the UI can warn, while
+// "replace" closes them, leaving one active session per user per room.
+// Any other value behaves like "notify".
+var duplicateSessionPolicy = envString("DUPLICATE_SESSION_POLICY", "notify")
+
+// duplicateSessionsLocked returns the other members of room authenticated
+// as the same user as client. Anonymous clients never match. The caller
+// must hold room.mu.
+func duplicateSessionsLocked(room *Room, client *Client) []*Client {
+	if client.UserID == "" {
+		return nil
+	}
+	var dups []*Client
+	for id, c := range room.Clients {
+		if id != client.ID && c.UserID == client.UserID {
+			dups = append(dups, c)
+		}
+	}
+	return dups
+}
+
+// resolveDuplicateSessions applies duplicateSessionPolicy to the existing
+// sessions of a user whose new connection, client, just joined
+func resolveDuplicateSessions(client *Client, dups []*Client) {
+	for _, old := range dups {
+		slog.Info("duplicate session", "event", "duplicate-session", "roomId", client.RoomID, "userId", client.UserID, "clientId", client.ID, "existingClientId", old.ID, "policy", duplicateSessionPolicy)
+		msg := Message{Type: "duplicate-session", From: client.ID, RoomID: client.RoomID}
+		if duplicateSessionPolicy == "replace" {
+			msg.Text = "You joined this meeting from another session"
+			old.SendAndClose(msg)
+			continue
+		}
+		old.Send(msg)
+	}
+}
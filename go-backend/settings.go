@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// RoomSettings are the per-meeting rules. They are set when a room is
+// created through the API and the host may change them at runtime with
+// update-settings.
+type RoomSettings struct {
+	// MaxClients caps the room below the server-wide maxRoomClients
+	MaxClients int `json:"maxClients"`
+	// ChatEnabled lets everyone chat and share files; the host always can
+	ChatEnabled bool `json:"chatEnabled"`
+	// GuestsCanUnmute lets participants other than the host turn their mic
+	// back on
+	GuestsCanUnmute bool `json:"guestsCanUnmute"`
+	// WaitingRoom makes joiners wait in Pending until the host admits them
+	WaitingRoom bool `json:"waitingRoom"`
+	// Transcript writes the room's chat to disk, see transcript.go
+	Transcript bool `json:"transcript"`
+}
+
+// defaultRoomSettings are the settings of rooms nobody configured
+func defaultRoomSettings() RoomSettings {
+	return RoomSettings{
+		MaxClients:      maxRoomClients,
+		ChatEnabled:     true,
+		GuestsCanUnmute: true,
+	}
+}
+
+// merge applies a partial JSON settings object on top of s; fields missing
+// from patch keep their current values
+func (s RoomSettings) merge(patch json.RawMessage) (RoomSettings, error) {
+	if err := json.Unmarshal(patch, &s); err != nil {
+		return s, errors.New("settings must be a JSON object")
+	}
+	if s.MaxClients < 1 || s.MaxClients > maxRoomClients {
+		return s, fmt.Errorf("maxClients must be between 1 and %d", maxRoomClients)
+	}
+	if s.Transcript && transcriptDir == "" {
+		return s, errors.New("transcripts are not enabled on this server")
+	}
+	return s, nil
+}
+
+// raw encodes s for the settings field of a message
+func (s RoomSettings) raw() json.RawMessage {
+	b, _ := json.Marshal(s)
+	return b
+}
+
+// chatAllowed reports whether client may chat or share files under the
+// room's settings
+func (r *Room) chatAllowed(client *Client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Settings.ChatEnabled || r.Host == client.ID
+}
+
+// updateSettings applies the host's settings change and tells the room.
+// Lowering maxClients never evicts anyone; turning the waiting room off
+// lets everyone waiting in.
+func (h *Hub) updateSettings(room *Room, from *Client, patch json.RawMessage) {
+	// For anyone let in from the waiting room, see admitLocked
+	remote := h.bus.remoteParticipants(room.ID)
+
+	room.mu.Lock()
+	if room.Host != from.ID {
+		room.mu.Unlock()
+		from.sendError("not-host", "only the host can change room settings")
+		return
+	}
+	settings, err := room.Settings.merge(patch)
+	if err != nil {
+		room.mu.Unlock()
+		from.sendError("invalid-settings", err.Error())
+		return
+	}
+	room.Settings = settings
+	slog.Info("room settings changed", "event", "settings-changed", "roomId", room.ID, "clientId", from.ID)
+	msg := Message{Type: "settings-changed", From: from.ID, RoomID: room.ID, Settings: settings.raw()}
+	room.broadcastLocked(msg)
+	from.Send(msg)
+
+	var admitted []*Client
+	var results []joinResult
+	if !settings.WaitingRoom {
+		for id, client := range room.Pending {
+			delete(room.Pending, id)
+			res, rejected := h.admitLocked(room, client, remote)
+			if rejected != "" {
+				client.SendAndClose(Message{Type: rejected, RoomID: room.ID})
+				continue
+			}
+			admitted = append(admitted, client)
+			results = append(results, res)
+		}
+	}
+	room.mu.Unlock()
+
+	for i, client := range admitted {
+		h.finishJoin(room, client, results[i])
+	}
+}
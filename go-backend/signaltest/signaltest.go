@@ -0,0 +1,155 @@
+// Package signaltest runs the signaling server in-process and drives it
+// with lightweight websocket clients, so signaling can be tested end to
+// end without a browser. From a test in package main:
+//
+//	srv := signaltest.NewServer(newRouter(NewHub(withAuthDisabled())))
+//	defer srv.Close()
+//
+//	alice, err := srv.Join("room-1", "alice")
+//	...
+//	bob, err := srv.Join("room-1", "bob")
+//	...
+//	alice.Expect("join", time.Second)
+//	bob.Send(map[string]any{"type": "chat", "message": "hi"})
+//	msg, err := alice.Expect("chat", time.Second)
+package signaltest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Server is the signaling server listening on an ephemeral loopback port
+type Server struct {
+	*httptest.Server
+}
+
+// NewServer starts serving handler, normally the server's router, and
+// returns once it is listening. Close it when done.
+func NewServer(handler http.Handler) *Server {
+	return &Server{httptest.NewServer(handler)}
+}
+
+// WebSocketURL is the /ws endpoint with query as its query string
+func (s *Server) WebSocketURL(query url.Values) string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/ws?" + query.Encode()
+}
+
+// Dial opens a websocket with the given query parameters (roomId,
+// clientId, username, token and so on) without waiting for anything
+func (s *Server) Dial(query url.Values) (*Client, error) {
+	conn, resp, err := websocket.DefaultDialer.Dial(s.WebSocketURL(query), nil)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("dialing %s: %w (status %d)", query.Get("clientId"), err, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("dialing %s: %w", query.Get("clientId"), err)
+	}
+	return &Client{conn: conn}, nil
+}
+
+// DefaultTimeout is how long Join waits for the server
+const DefaultTimeout = 2 * time.Second
+
+// Join connects clientID, also used as its username, to roomID and waits
+// for the joined acknowledgement, so the client is a member of the room
+// when it returns. The hub must have auth disabled; otherwise use Dial
+// with a token.
+func (s *Server) Join(roomID, clientID string) (*Client, error) {
+	c, err := s.Dial(url.Values{"roomId": {roomID}, "clientId": {clientID}, "username": {clientID}})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.Expect("joined", DefaultTimeout); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Message holds the fields of a server message that tests check most
+// often; Raw is the whole frame for decoding anything else
+type Message struct {
+	Type          string          `json:"type"`
+	From          string          `json:"from"`
+	To            string          `json:"to,omitempty"`
+	RoomID        string          `json:"roomId"`
+	Username      string          `json:"username,omitempty"`
+	SDP           json.RawMessage `json:"sdp,omitempty"`
+	Candidate     json.RawMessage `json:"candidate,omitempty"`
+	Text          string          `json:"message,omitempty"`
+	Code          string          `json:"code,omitempty"`
+	Host          string          `json:"host,omitempty"`
+	MsgID         string          `json:"msgId,omitempty"`
+	CorrelationID string          `json:"correlationId,omitempty"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+// Decode unmarshals the whole frame into v
+func (m Message) Decode(v any) error {
+	return json.Unmarshal(m.Raw, v)
+}
+
+// Client is one websocket connection to the server, speaking JSON. Like
+// the underlying connection it must not be used from several goroutines
+// at once.
+type Client struct {
+	conn *websocket.Conn
+}
+
+// Send writes msg, any value that marshals to a client message, e.g. a
+// map or a struct with the server's JSON field names
+func (c *Client) Send(msg any) error {
+	return c.conn.WriteJSON(msg)
+}
+
+// Receive returns the next message, or an error if none arrives within
+// timeout or the connection closes
+func (c *Client) Receive(timeout time.Duration) (Message, error) {
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	_, data, err := c.conn.ReadMessage()
+	if err != nil {
+		return Message{}, err
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return Message{}, fmt.Errorf("decoding %q: %w", data, err)
+	}
+	msg.Raw = data
+	return msg, nil
+}
+
+// Expect skips messages until one of type msgType arrives, returning it,
+// or fails once timeout has passed in total. Skipping keeps tests from
+// depending on the exact order of unrelated notifications.
+func (c *Client) Expect(msgType string, timeout time.Duration) (Message, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return Message{}, errors.New("timed out waiting for " + msgType)
+		}
+		msg, err := c.Receive(remaining)
+		if err != nil {
+			return Message{}, fmt.Errorf("waiting for %s: %w", msgType, err)
+		}
+		if msg.Type == msgType {
+			return msg, nil
+		}
+	}
+}
+
+// Close closes the connection without a close handshake, as a browser tab
+// being killed would; the server treats it as the client leaving
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+// messageCounts tallies messages received since start by type, for
+// /api/stats. It is filled once at init and only read afterwards, so the
+// map itself needs no lock.
+var messageCounts = func() map[string]*atomic.Int64 {
+	counts := map[string]*atomic.Int64{"other": new(atomic.Int64)}
+	for msgType := range clientMessageTypes {
+		counts[msgType] = new(atomic.Int64)
+	}
+	return counts
+}()
+
+// countMessageType adds one to msgType's count, lumping unknown types
+// together so clients can't grow the map
+func countMessageType(msgType string) {
+	count, ok := messageCounts[msgType]
+	if !ok {
+		count = messageCounts["other"]
+	}
+	count.Add(1)
+}
+
+// heapBytesMetric is the live heap, read through runtime/metrics since
+// runtime.ReadMemStats stops the world
+const heapBytesMetric = "/memory/classes/heap/objects:bytes"
+
+// handleStats serves a JSON status snapshot for deployments without
+// Prometheus. Everything comes from atomic counters, so it never waits on
+// a room's lock. Admin only.
+func handleStats(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			writeJSONError(w, http.StatusForbidden, "Admin API key required")
+			return
+		}
+
+		rooms := make(map[string]int64)
+		for _, room := range hub.ListRooms() {
+			rooms[room.ID] = room.numClients.Load()
+		}
+		messages := make(map[string]int64, len(messageCounts))
+		for msgType, count := range messageCounts {
+			if n := count.Load(); n > 0 {
+				messages[msgType] = n
+			}
+		}
+		sample := []metrics.Sample{{Name: heapBytesMetric}}
+		metrics.Read(sample)
+		var heapBytes uint64
+		if sample[0].Value.Kind() == metrics.KindUint64 {
+			heapBytes = sample[0].Value.Uint64()
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"uptime":      time.Since(startTime).Round(time.Second).String(),
+			"rooms":       hub.numRooms.Load(),
+			"clients":     hub.numClients.Load(),
+			"connections": hub.numConns.Load(),
+			"roomClients": rooms,
+			"messages":    messages,
+			"heapBytes":   heapBytes,
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// transcriptDir turns on chat transcripts when set. Rooms whose
+	// Settings.Transcript is on append their chat to
+	// <dir>/<roomId>/<instanceId>.jsonl, so a room that ends and is created
+	// again under the same ID starts a new transcript.
+	transcriptDir = envString("TRANSCRIPT_DIR", "")
+	// transcriptMaxBytes is the size at which a transcript is rotated, and
+	// transcriptMaxFiles how many rotated files are kept besides the
+	// current one; older ones are deleted
+	transcriptMaxBytes = int64(envInt("TRANSCRIPT_MAX_BYTES", 10<<20))
+	transcriptMaxFiles = envInt("TRANSCRIPT_MAX_FILES", 3)
+	// transcriptFlushInterval bounds how much buffered chat a crash can
+	// lose; writes in between go to memory only
+	transcriptFlushInterval = time.Duration(envInt("TRANSCRIPT_FLUSH_INTERVAL_MS", 1000)) * time.Millisecond
+	// transcriptIdleClose is how long a room's file stays open without
+	// writes, so ended rooms don't hold file descriptors forever
+	transcriptIdleClose = time.Minute
+)
+
+// transcriptEntry is one chat message waiting to be written to the
+// transcript named by key, see transcriptKey
+type transcriptEntry struct {
+	key string
+	msg Message
+}
+
+// transcriptKey names the transcript of one instance of a room
+func transcriptKey(roomID, instanceID string) string {
+	return filepath.Join(roomID, instanceID)
+}
+
+// transcriptWriter appends chat messages to per-room JSON lines files. The
+// broadcast path only queues entries; a single background goroutine, run,
+// does all the file I/O.
+type transcriptWriter struct {
+	dir     string
+	entries chan transcriptEntry
+	quit    chan struct{}
+	stopped chan struct{}
+
+	// mu guards files, keyed by transcriptKey, which run and downloads
+	// both use
+	mu    sync.Mutex
+	files map[string]*transcriptFile
+}
+
+// transcriptFile is a room's open, buffered transcript
+type transcriptFile struct {
+	f         *os.File
+	w         *bufio.Writer
+	size      int64
+	lastWrite time.Time
+}
+
+// newTranscriptWriter creates dir if needed and starts the writer
+func newTranscriptWriter(dir string) (*transcriptWriter, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	t := &transcriptWriter{
+		dir:     dir,
+		entries: make(chan transcriptEntry, 1024),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		files:   make(map[string]*transcriptFile),
+	}
+	go t.run()
+	return t, nil
+}
+
+// append queues msg for room's transcript without blocking. If the
+// writer has fallen that far behind the message is left out of the
+// transcript rather than stalling the room. The caller must hold room.mu.
+func (t *transcriptWriter) append(room *Room, msg Message) {
+	select {
+	case <-t.quit:
+	case t.entries <- transcriptEntry{key: transcriptKey(room.ID, room.InstanceID), msg: msg}:
+	default:
+		slog.Warn("transcript buffer full, dropping message", "roomId", room.ID, "correlationId", msg.CorrelationID)
+		transcriptDroppedTotal.Inc()
+	}
+}
+
+// stop writes out everything queued so far, closes the files and waits
+// for run to exit. Messages appended afterwards are ignored.
+func (t *transcriptWriter) stop() {
+	close(t.quit)
+	<-t.stopped
+}
+
+func (t *transcriptWriter) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(transcriptFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-t.entries:
+			t.write(e)
+		case now := <-ticker.C:
+			t.flush(now)
+		case <-t.quit:
+			for {
+				select {
+				case e := <-t.entries:
+					t.write(e)
+				default:
+					t.closeAll()
+					return
+				}
+			}
+		}
+	}
+}
+
+// write appends one entry, rotating the file first if it would grow past
+// transcriptMaxBytes
+func (t *transcriptWriter) write(e transcriptEntry) {
+	line, err := json.Marshal(e.msg)
+	if err != nil {
+		slog.Error("error marshaling transcript entry", "transcript", e.key, "err", err)
+		return
+	}
+	line = append(line, '\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tf, err := t.openLocked(e.key)
+	if err == nil && tf.size > 0 && tf.size+int64(len(line)) > transcriptMaxBytes {
+		if err = t.rotateLocked(e.key); err == nil {
+			tf, err = t.openLocked(e.key)
+		}
+	}
+	if err != nil {
+		slog.Error("error opening transcript", "transcript", e.key, "err", err)
+		return
+	}
+	n, err := tf.w.Write(line)
+	tf.size += int64(n)
+	tf.lastWrite = time.Now()
+	if err != nil {
+		slog.Error("error writing transcript", "transcript", e.key, "err", err)
+	}
+}
+
+// flush writes out buffered lines and closes files that have gone idle
+func (t *transcriptWriter) flush(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, tf := range t.files {
+		if err := tf.w.Flush(); err != nil {
+			slog.Error("error flushing transcript", "transcript", key, "err", err)
+		}
+		if now.Sub(tf.lastWrite) >= transcriptIdleClose {
+			t.closeLocked(key)
+		}
+	}
+}
+
+func (t *transcriptWriter) closeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key := range t.files {
+		t.closeLocked(key)
+	}
+}
+
+// path is where key's current transcript lives; rotated files add a
+// .1, .2, ... suffix, .1 being the most recent
+func (t *transcriptWriter) path(key string, generation int) string {
+	p := filepath.Join(t.dir, key+".jsonl")
+	if generation > 0 {
+		p += fmt.Sprintf(".%d", generation)
+	}
+	return p
+}
+
+// openLocked returns key's open transcript, opening it for append if
+// needed. The caller must hold t.mu.
+func (t *transcriptWriter) openLocked(key string) (*transcriptFile, error) {
+	if tf, ok := t.files[key]; ok {
+		return tf, nil
+	}
+	path := t.path(key, 0)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tf := &transcriptFile{f: f, w: bufio.NewWriter(f), size: info.Size()}
+	t.files[key] = tf
+	return tf, nil
+}
+
+// closeLocked flushes, syncs and closes key's transcript if it is open.
+// The caller must hold t.mu.
+func (t *transcriptWriter) closeLocked(key string) {
+	tf, ok := t.files[key]
+	if !ok {
+		return
+	}
+	delete(t.files, key)
+	if err := tf.w.Flush(); err != nil {
+		slog.Error("error flushing transcript", "transcript", key, "err", err)
+	}
+	tf.f.Sync()
+	tf.f.Close()
+}
+
+// rotateLocked shifts key's transcripts down one generation, dropping
+// the oldest. The caller must hold t.mu.
+func (t *transcriptWriter) rotateLocked(key string) error {
+	t.closeLocked(key)
+	os.Remove(t.path(key, transcriptMaxFiles))
+	for gen := transcriptMaxFiles - 1; gen >= 0; gen-- {
+		err := os.Rename(t.path(key, gen), t.path(key, gen+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// openAll flushes key's transcript and opens every generation of it for
+// reading, oldest first. Opening them all under t.mu gives a consistent
+// set even if the file is rotated while the caller reads.
+func (t *transcriptWriter) openAll(key string) ([]*os.File, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if tf, ok := t.files[key]; ok {
+		if err := tf.w.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	var files []*os.File
+	for gen := transcriptMaxFiles; gen >= 0; gen-- {
+		f, err := os.Open(t.path(key, gen))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// handleRoomTranscript streams the chat transcript of a room's current
+// instance as JSON lines. It requires the host token or the admin API key.
+// Once the room has ended only the admin key can fetch it, naming the
+// instance, as reported when the room was created, in ?instance=.
+func handleRoomTranscript(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hub.transcripts == nil {
+			writeJSONError(w, http.StatusNotFound, "Transcripts are not enabled")
+			return
+		}
+		roomID, err := validateRoomID(r.PathValue("roomId"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		room, exists := hub.GetRoom(roomID)
+		admin := isAdmin(r)
+		if !admin && (!exists || !secretEqual(bearerToken(r), room.HostToken)) {
+			writeJSONError(w, http.StatusForbidden, "Not allowed to view this room's transcript")
+			return
+		}
+		var instanceID string
+		if exists {
+			instanceID = room.InstanceID
+		}
+		if v := r.URL.Query().Get("instance"); admin && v != "" {
+			if !validCorrelationID(v) {
+				writeJSONError(w, http.StatusBadRequest, "Invalid instance")
+				return
+			}
+			instanceID = v
+		}
+		if instanceID == "" {
+			writeJSONError(w, http.StatusNotFound, "Room has ended; pass its instance to fetch the transcript")
+			return
+		}
+
+		files, err := hub.transcripts.openAll(transcriptKey(roomID, instanceID))
+		if err != nil {
+			slog.Error("error opening transcript", "roomId", roomID, "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if len(files) == 0 {
+			writeJSONError(w, http.StatusNotFound, "No transcript for this room")
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, f := range files {
+			if err == nil {
+				_, err = io.Copy(w, f)
+			}
+			f.Close()
+		}
+		if err != nil {
+			slog.Warn("error sending transcript", "roomId", roomID, "err", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import "time"
+
+// typingTimeout is how long a typing indicator stays on without a fresh
+// typing=true from the client
+const typingTimeout = 5 * time.Second
+
+// setTyping relays a client's typing state to the rest of the room. Only
+// transitions are broadcast, so a client repeating typing=true on every
+// keystroke just extends the timeout. If the client never sends
+// typing=false, the server sends it once the timeout elapses.
+func (h *Hub) setTyping(client *Client, isTyping bool) {
+	client.typingMu.Lock()
+	defer client.typingMu.Unlock()
+
+	if client.typingTimer != nil {
+		client.typingTimer.Stop()
+		client.typingTimer = nil
+	}
+
+	changed := client.typing != isTyping
+	client.typing = isTyping
+
+	if isTyping {
+		var timer *time.Timer
+		timer = time.AfterFunc(typingTimeout, func() {
+			client.typingMu.Lock()
+			// A newer typing message may have replaced this timer
+			expired := client.typingTimer == timer
+			if expired {
+				client.typing = false
+				client.typingTimer = nil
+			}
+			client.typingMu.Unlock()
+
+			if expired {
+				h.broadcastTyping(client, false)
+			}
+		})
+		client.typingTimer = timer
+	}
+
+	if changed {
+		h.broadcastTyping(client, isTyping)
+	}
+}
+
+// stopTyping cancels any pending typing timeout without broadcasting,
+// used when the client leaves
+func (c *Client) stopTyping() {
+	c.typingMu.Lock()
+	defer c.typingMu.Unlock()
+
+	if c.typingTimer != nil {
+		c.typingTimer.Stop()
+		c.typingTimer = nil
+	}
+	c.typing = false
+}
+
+func (h *Hub) broadcastTyping(client *Client, isTyping bool) {
+	h.broadcastToRoom(client.RoomID, Message{
+		Type:     "typing",
+		From:     client.ID,
+		RoomID:   client.RoomID,
+		IsTyping: &isTyping,
+	})
+}
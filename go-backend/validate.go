@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxClientIDLength = 64
+	maxRoomIDLength   = 64
+	maxUsernameLength = 64
+	maxFileNameLength = 255
+	maxURLLength      = 2048
+	// maxCapabilitiesLength caps the capabilities a client advertises,
+	// which are copied into every roster it appears in
+	maxCapabilitiesLength = 4 << 10
+	// maxWelcomeMessageLength is in characters, like maxUsernameLength
+	maxWelcomeMessageLength = 1000
+)
+
+// maxMalformedMessages is how many consecutive non-JSON payloads a client
+// may send before it is disconnected as misbehaving
+var maxMalformedMessages = envInt("MAX_MALFORMED_MESSAGES", 10)
+
+// maxRelayPayloadSize caps the opaque payload of a relay message
+var maxRelayPayloadSize = envInt("RELAY_MAX_PAYLOAD_BYTES", 16<<10)
+
+// maxSDPSize caps a session description; real ones are a few KB even with
+// many tracks
+var maxSDPSize = envInt("MAX_SDP_BYTES", 64<<10)
+
+// maxCandidateSize caps an ICE candidate, which is a single short line
+// plus its sdpMid and index
+var maxCandidateSize = envInt("MAX_CANDIDATE_BYTES", 2<<10)
+
+// maxFileShareSize caps the advertised size of shared files
+var maxFileShareSize = int64(envInt("MAX_FILE_SHARE_BYTES", 100<<20))
+
+var mimeTypePattern = regexp.MustCompile(`^[\w.+-]+/[\w.+-]+$`)
+
+// clientIDPattern accepts UUIDs and similar opaque tokens
+var clientIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// validateClientID trims id and checks it is a short opaque token
+func validateClientID(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return "", errors.New("clientId is required")
+	}
+	if len(id) > maxClientIDLength {
+		return "", errors.New("clientId is too long")
+	}
+	if !clientIDPattern.MatchString(id) {
+		return "", errors.New("clientId may only contain letters, digits, '-' and '_'")
+	}
+	return id, nil
+}
+
+// validateUserID trims id and checks it is a short opaque token like a
+// clientId
+func validateUserID(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if len(id) > maxClientIDLength {
+		return "", errors.New("userId is too long")
+	}
+	if !clientIDPattern.MatchString(id) {
+		return "", errors.New("userId may only contain letters, digits, '-' and '_'")
+	}
+	return id, nil
+}
+
+// validateCapabilities checks a client's advertised capabilities are a
+// small JSON object and returns them compacted. They are relayed to peers
+// as-is and never interpreted. Empty means none were advertised.
+func validateCapabilities(raw string) (json.RawMessage, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	if len(raw) > maxCapabilitiesLength {
+		return nil, errors.New("capabilities are too large")
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil || fields == nil {
+		return nil, errors.New("capabilities must be a JSON object")
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(raw)); err != nil {
+		return nil, errors.New("capabilities must be a JSON object")
+	}
+	return buf.Bytes(), nil
+}
+
+// validCorrelationID reports whether a client-supplied correlation ID is
+// safe to log and relay as-is
+func validCorrelationID(id string) bool {
+	return id != "" && len(id) <= maxClientIDLength && clientIDPattern.MatchString(id)
+}
+
+// roomIDPattern keeps room IDs safe to embed in a URL path as-is
+var roomIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// validateRoomID trims id and checks it is a short URL-safe slug
+func validateRoomID(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return "", errors.New("roomId is required")
+	}
+	if len(id) > maxRoomIDLength {
+		return "", errors.New("roomId is too long")
+	}
+	if !roomIDPattern.MatchString(id) {
+		return "", errors.New("roomId may only contain letters, digits, '-' and '_'")
+	}
+	return id, nil
+}
+
+// validateUsername trims name and checks it is short and printable
+func validateUsername(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("username is required")
+	}
+	if !utf8.ValidString(name) {
+		return "", errors.New("username is not valid UTF-8")
+	}
+	if utf8.RuneCountInString(name) > maxUsernameLength {
+		return "", errors.New("username is too long")
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return "", errors.New("username contains non-printable characters")
+		}
+	}
+	return name, nil
+}
+
+// validateWelcomeMessage trims a room's welcome message and checks it is
+// short, valid text. Empty means no welcome message.
+func validateWelcomeMessage(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if !utf8.ValidString(text) {
+		return "", errors.New("welcomeMessage is not valid UTF-8")
+	}
+	if utf8.RuneCountInString(text) > maxWelcomeMessageLength {
+		return "", errors.New("welcomeMessage is too long")
+	}
+	return text, nil
+}
+
+// clientMessageTypes is every message type clients may send. Anything
+// else is rejected with an error so client bugs surface instead of being
+// silently ignored.
+var clientMessageTypes = map[string]bool{
+	"offer":           true,
+	"answer":          true,
+	"ice-candidate":   true,
+	"chat":            true,
+	"kick":            true,
+	"transfer-host":   true,
+	"admit":           true,
+	"deny":            true,
+	"media-state":     true,
+	"typing":          true,
+	"file-share":      true,
+	"screen-share":    true,
+	"raise-hand":      true,
+	"stats-report":    true,
+	"set-quality":     true,
+	"rename":          true,
+	"recording-start": true,
+	"recording-stop":  true,
+	"lock-room":       true,
+	"unlock-room":     true,
+	"link-room":       true,
+	"unlink-room":     true,
+	"accept-link":     true,
+	"set-presenter":   true,
+	"set-welcome":     true,
+	"create-breakout": true,
+	"relay":           true,
+	"update-settings": true,
+	"close-breakout":  true,
+	"echo":            true,
+	"get-room-info":   true,
+	"get-features":    true,
+	"request-roster":  true,
+	"ice-restart":     true,
+	"renegotiate":     true,
+}
+
+// protocolError is reported back to the sender as an "error" message
+type protocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *protocolError) Error() string { return e.Message }
+
+// validateMessage checks the type is known and that signaling messages
+// carry the fields their recipient needs
+func validateMessage(msg Message) *protocolError {
+	if msg.Type == "" {
+		return &protocolError{"missing-type", "message type is required"}
+	}
+	if !clientMessageTypes[msg.Type] {
+		return &protocolError{"unknown-type", "unknown message type: " + msg.Type}
+	}
+
+	// Checked for every type, since any message may carry these fields and
+	// they are forwarded as they are, to a whole room for a multicast
+	if len(msg.SDP) > maxSDPSize {
+		return &protocolError{"field-too-large", "sdp exceeds the size limit"}
+	}
+	if len(msg.Candidate) > maxCandidateSize {
+		return &protocolError{"field-too-large", "candidate exceeds the size limit"}
+	}
+
+	if len(msg.ToMany) > 0 {
+		if perr := validateToMany(msg); perr != nil {
+			return perr
+		}
+	}
+
+	switch msg.Type {
+	case "offer", "answer":
+		if msg.To == "" && len(msg.ToMany) == 0 {
+			return &protocolError{"missing-field", msg.Type + " requires to or toMany"}
+		}
+		if len(msg.SDP) == 0 {
+			return &protocolError{"missing-field", msg.Type + " requires sdp"}
+		}
+		if perr := validateSDP(msg.Type, msg.SDP); perr != nil {
+			return perr
+		}
+	case "ice-candidate":
+		if msg.To == "" && len(msg.ToMany) == 0 {
+			return &protocolError{"missing-field", "ice-candidate requires to or toMany"}
+		}
+		if len(msg.Candidate) == 0 {
+			return &protocolError{"missing-field", "ice-candidate requires candidate"}
+		}
+	case "ice-restart", "renegotiate":
+		if msg.To == "" && len(msg.ToMany) == 0 {
+			return &protocolError{"missing-field", msg.Type + " requires to or toMany"}
+		}
+		if msg.Reason != "" && (len(msg.Reason) > maxClientIDLength || !clientIDPattern.MatchString(msg.Reason)) {
+			return &protocolError{"invalid-reason", "reason must be a short token of letters, digits, '-' and '_'"}
+		}
+		if len(msg.SDP) > 0 {
+			if perr := validateSDP("offer", msg.SDP); perr != nil {
+				return perr
+			}
+		}
+	case "link-room", "unlink-room", "accept-link":
+		if msg.LinkedRoom == "" {
+			return &protocolError{"missing-field", msg.Type + " requires linkedRoom"}
+		}
+		if _, err := validateRoomID(msg.LinkedRoom); err != nil {
+			return &protocolError{"invalid-field", err.Error()}
+		}
+	case "chat":
+		if msg.MsgID != "" && (len(msg.MsgID) > maxClientIDLength || !clientIDPattern.MatchString(msg.MsgID)) {
+			return &protocolError{"invalid-msg-id", "msgId must be a short token of letters, digits, '-' and '_'"}
+		}
+	case "set-quality":
+		if msg.To == "" {
+			return &protocolError{"missing-field", "set-quality requires targetClientId or to"}
+		}
+		if !simulcastLayers[msg.Layer] {
+			return &protocolError{"invalid-layer", "layer must be low, medium or high"}
+		}
+	case "kick", "admit", "deny", "transfer-host":
+		if msg.To == "" {
+			return &protocolError{"missing-field", msg.Type + " requires to"}
+		}
+	case "screen-share":
+		if msg.Sharing == nil {
+			return &protocolError{"missing-field", "screen-share requires sharing"}
+		}
+	case "raise-hand":
+		if msg.Raised == nil {
+			return &protocolError{"missing-field", "raise-hand requires raised"}
+		}
+	case "stats-report":
+		if msg.Stats == nil {
+			return &protocolError{"missing-field", "stats-report requires stats"}
+		}
+		if !msg.Stats.valid() {
+			return &protocolError{"invalid-stats", "stats must be non-negative numbers and packetLoss at most 100"}
+		}
+	case "update-settings":
+		if len(msg.Settings) == 0 {
+			return &protocolError{"missing-field", "update-settings requires settings"}
+		}
+	case "relay":
+		if (msg.To == "" && len(msg.ToMany) == 0) || len(msg.Payload) == 0 {
+			return &protocolError{"missing-field", "relay requires to or toMany, and payload"}
+		}
+		if len(msg.Payload) > maxRelayPayloadSize {
+			return &protocolError{"payload-too-large", "relay payload exceeds the size limit"}
+		}
+	case "create-breakout":
+		if len(msg.Breakout) > maxRoomIDLength || !roomIDPattern.MatchString(msg.Breakout) {
+			return &protocolError{"invalid-breakout", "breakout must be a short name of letters, digits, '-' and '_'"}
+		}
+		if len(msg.ClientIDs) == 0 {
+			return &protocolError{"missing-field", "create-breakout requires clientIds"}
+		}
+	case "set-welcome":
+		if _, err := validateWelcomeMessage(msg.Text); err != nil {
+			return &protocolError{"invalid-welcome", err.Error()}
+		}
+	case "rename":
+		if _, err := validateUsername(msg.Username); err != nil {
+			return &protocolError{"invalid-username", err.Error()}
+		}
+	case "file-share":
+		return validateFileShare(msg)
+	case "echo":
+		if len(msg.Payload) > maxRelayPayloadSize {
+			return &protocolError{"payload-too-large", "echo payload exceeds the size limit"}
+		}
+	}
+	return nil
+}
+
+// sdpTypes are the RTCSessionDescription types each signal may carry
+var sdpTypes = map[string][]string{
+	"offer":  {"offer", "rollback"},
+	"answer": {"answer", "pranswer", "rollback"},
+}
+
+// validateSDP checks that raw is a session description of the shape
+// browsers produce, {"type": ..., "sdp": ...}, and that its sdp at least
+// looks like SDP, so a peer is never handed something that could break
+// its WebRTC stack. It doesn't try to parse the session beyond that; the
+// size limit is checked up front by validateMessage.
+func validateSDP(msgType string, raw json.RawMessage) *protocolError {
+	var desc struct {
+		Type string  `json:"type"`
+		SDP  *string `json:"sdp"`
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) || json.Unmarshal(raw, &desc) != nil {
+		return &protocolError{"invalid-sdp", "sdp must be an object with type and sdp"}
+	}
+	allowed := sdpTypes[msgType]
+	if !slices.Contains(allowed, desc.Type) {
+		return &protocolError{"invalid-sdp", "sdp type must be one of " + strings.Join(allowed, ", ")}
+	}
+	if desc.Type == "rollback" {
+		return nil
+	}
+	if desc.SDP == nil {
+		return &protocolError{"invalid-sdp", "sdp requires an sdp string"}
+	}
+	if !wellFormedSDP(*desc.SDP) {
+		return &protocolError{"invalid-sdp", "sdp is not a valid session description"}
+	}
+	return nil
+}
+
+// wellFormedSDP reports whether s is line-by-line SDP (RFC 8866): it opens
+// with v=0 and every line is a lowercase letter, '=' and printable text
+func wellFormedSDP(s string) bool {
+	if !utf8.ValidString(s) || !strings.HasPrefix(s, "v=0") {
+		return false
+	}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 || line[0] < 'a' || line[0] > 'z' || line[1] != '=' {
+			return false
+		}
+		if strings.ContainsFunc(line, func(r rune) bool { return unicode.IsControl(r) && r != '\t' }) {
+			return false
+		}
+	}
+	return true
+}
+
+// multicastMessageTypes may be addressed to several peers at once with
+// toMany
+var multicastMessageTypes = map[string]bool{
+	"offer":         true,
+	"answer":        true,
+	"ice-candidate": true,
+	"relay":         true,
+	"ice-restart":   true,
+	"renegotiate":   true,
+}
+
+// validateToMany checks the recipient list of a multicast message
+func validateToMany(msg Message) *protocolError {
+	if !multicastMessageTypes[msg.Type] {
+		return &protocolError{"invalid-field", msg.Type + " does not support toMany"}
+	}
+	if msg.To != "" {
+		return &protocolError{"invalid-field", "to and toMany are mutually exclusive"}
+	}
+	if len(msg.ToMany) > maxRoomClients {
+		return &protocolError{"invalid-field", "toMany lists more peers than a room can hold"}
+	}
+	for _, id := range msg.ToMany {
+		if id == "" {
+			return &protocolError{"invalid-field", "toMany contains an empty clientId"}
+		}
+	}
+	return nil
+}
+
+// validateFileShare checks file metadata is plausible and the link is a
+// plain http(s) URL
+func validateFileShare(msg Message) *protocolError {
+	if msg.FileName == "" || len(msg.FileName) > maxFileNameLength || !utf8.ValidString(msg.FileName) {
+		return &protocolError{"invalid-file", "fileName must be 1-255 bytes of UTF-8"}
+	}
+	for _, r := range msg.FileName {
+		if !unicode.IsPrint(r) || r == '/' || r == '\\' {
+			return &protocolError{"invalid-file", "fileName contains invalid characters"}
+		}
+	}
+	if msg.FileSize <= 0 || msg.FileSize > maxFileShareSize {
+		return &protocolError{"invalid-file", "fileSize is out of range"}
+	}
+	if msg.MimeType != "" && (len(msg.MimeType) > 127 || !mimeTypePattern.MatchString(msg.MimeType)) {
+		return &protocolError{"invalid-file", "mimeType is malformed"}
+	}
+	if len(msg.URL) > maxURLLength {
+		return &protocolError{"invalid-file", "url is too long"}
+	}
+	u, err := url.Parse(msg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &protocolError{"invalid-file", "url must be an absolute http or https URL"}
+	}
+	return nil
+}
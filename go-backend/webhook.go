@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var (
+	// webhookURL turns on room event webhooks when set; every event is
+	// POSTed there as JSON
+	webhookURL = envString("WEBHOOK_URL", "")
+	// webhookSecret signs each body with HMAC-SHA256 so the receiver can
+	// check it came from us, see sign
+	webhookSecret = envString("WEBHOOK_SECRET", "")
+	// webhookMaxAttempts bounds delivery of one event, retrying with
+	// exponential backoff from webhookRetryDelay
+	webhookMaxAttempts = envInt("WEBHOOK_MAX_ATTEMPTS", 5)
+	webhookRetryDelay  = time.Duration(envInt("WEBHOOK_RETRY_DELAY_MS", 500)) * time.Millisecond
+	webhookTimeout     = time.Duration(envInt("WEBHOOK_TIMEOUT_SECONDS", 5)) * time.Second
+)
+
+// Webhook event names
+const (
+	webhookRoomCreated      = "room.created"
+	webhookRoomStarted      = "room.started"
+	webhookRoomEnded        = "room.ended"
+	webhookRoomDestroyed    = "room.destroyed"
+	webhookRecordingStarted = "recording.started"
+	webhookRecordingStopped = "recording.stopped"
+)
+
+// webhookEvent is the body of a webhook request. ID is unique per event,
+// so a receiver can drop the duplicates retries may cause.
+type webhookEvent struct {
+	ID     string `json:"id"`
+	Event  string `json:"event"`
+	RoomID string `json:"roomId"`
+	// InstanceID tells apart rooms that reused an ID, see Room.InstanceID
+	InstanceID string    `json:"instanceId"`
+	ClientID   string    `json:"clientId,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// webhookSender delivers room events to webhookURL. Like the transcript
+// writer, callers only queue events, often while holding a room's lock; a
+// single goroutine, run, does the HTTP requests, in order.
+type webhookSender struct {
+	url     string
+	secret  string
+	client  *http.Client
+	events  chan webhookEvent
+	quit    chan struct{}
+	stopped chan struct{}
+}
+
+func newWebhookSender(url, secret string) *webhookSender {
+	w := &webhookSender{
+		url:     url,
+		secret:  secret,
+		client:  &http.Client{Timeout: webhookTimeout},
+		events:  make(chan webhookEvent, 256),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// emit queues an event without blocking; it is a no-op on a nil sender so
+// call sites needn't check whether webhooks are on. Events that don't fit
+// in the queue are dropped rather than stalling signaling.
+func (w *webhookSender) emit(event string, room *Room, clientID string) {
+	if w == nil {
+		return
+	}
+	e := webhookEvent{ID: randomString(16), Event: event, RoomID: room.ID, InstanceID: room.InstanceID, ClientID: clientID, Timestamp: time.Now()}
+	select {
+	case <-w.quit:
+	case w.events <- e:
+	default:
+		slog.Warn("webhook queue full, dropping event", "event", event, "roomId", room.ID)
+		webhooksFailedTotal.Inc()
+	}
+}
+
+// stop delivers what is already queued, one attempt each, until ctx is
+// done, then waits for run to exit
+func (w *webhookSender) stop(ctx context.Context) {
+	close(w.quit)
+	select {
+	case <-w.stopped:
+	case <-ctx.Done():
+	}
+}
+
+func (w *webhookSender) run() {
+	defer close(w.stopped)
+	for {
+		select {
+		case e := <-w.events:
+			w.deliver(e, webhookMaxAttempts)
+		case <-w.quit:
+			for {
+				select {
+				case e := <-w.events:
+					w.deliver(e, 1)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver POSTs e, retrying network errors, 429s and 5xx responses up to
+// attempts times in total. Other responses mean the receiver rejected the
+// event and retrying wouldn't help.
+func (w *webhookSender) deliver(e webhookEvent, attempts int) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("error marshaling webhook event", "event", e.Event, "roomId", e.RoomID, "err", err)
+		return
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := w.post(e.Event, body)
+		if err == nil {
+			slog.Debug("webhook delivered", "event", e.Event, "roomId", e.RoomID, "id", e.ID)
+			return
+		}
+		retryable := true
+		var status webhookStatusError
+		if errors.As(err, &status) {
+			retryable = status == http.StatusTooManyRequests || status >= 500
+		}
+		if !retryable || attempt >= attempts {
+			slog.Warn("webhook delivery failed", "event", e.Event, "roomId", e.RoomID, "id", e.ID, "attempts", attempt, "err", err)
+			webhooksFailedTotal.Inc()
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-w.quit:
+			// Shutting down: give up on retries so the rest of the queue
+			// gets its one attempt
+			attempts = attempt + 1
+		}
+		delay *= 2
+	}
+}
+
+// webhookStatusError is a non-2xx webhook response
+type webhookStatusError int
+
+func (e webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook responded %d", int(e))
+}
+
+func (w *webhookSender) post(event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+	if w.secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+w.sign(body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return webhookStatusError(resp.StatusCode)
+	}
+	return nil
+}
+
+// sign returns the hex HMAC-SHA256 of body under the webhook secret. The
+// receiver recomputes it over the raw request body and compares in
+// constant time; the timestamp inside the body lets it reject replays.
+func (w *webhookSender) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
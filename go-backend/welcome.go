@@ -0,0 +1,30 @@
+package main
+
+import "log/slog"
+
+// welcomeLocked returns the system message greeting a joiner, or nil if
+// the room has no welcome message. The caller must hold r.mu.
+func (r *Room) welcomeLocked() *Message {
+	if r.WelcomeMessage == "" {
+		return nil
+	}
+	return &Message{Type: "system", Code: "welcome", RoomID: r.ID, Text: r.WelcomeMessage}
+}
+
+// setWelcomeMessage replaces the message shown to future joiners; an
+// empty text removes it. Only the host may change it, and nobody already
+// in the room is shown the new one.
+func (r *Room) setWelcomeMessage(client *Client, text string) {
+	text, _ = validateWelcomeMessage(text)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Host != client.ID {
+		client.sendError("not-host", "only the host can change the welcome message")
+		return
+	}
+	r.WelcomeMessage = text
+	slog.Info("welcome message changed", "event", "welcome-changed", "roomId", r.ID, "clientId", client.ID)
+	client.Send(Message{Type: "welcome-updated", RoomID: r.ID, Text: text})
+}